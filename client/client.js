@@ -375,7 +375,7 @@ function createScrapeRow(scrape) {
     c1.innerText = scrape["time"];
     c1.classList.add("time");
 
-    if (scrape["result"] == "success") {
+    if (scrape["result"] == "success" && scrape["file"]) {
         c2.innerHTML = "<a href=" + scrape["file"] + " target=\"_blank\">" + scrape["result"] + "</a>";
     } else {
         c2.innerText = scrape["result"];
@@ -429,6 +429,11 @@ function showInfo() {
 
         // Name, active
         addPropValueToElement(cInfoBox, cam["name"], "active = " + cam["is_active"]);
+        // health warning (nothing if healthy)
+        if (cam["health"] && cam["health"] != "healthy") {
+            addPropValueToElement(cInfoBox, "Health",
+                '<span class="health-warning">' + cam["health"] + '</span>');
+        }
         // elevation
         addPropValueToElement(cInfoBox, "Elevation (ft)", cam["elevation_ft"]);
         // lat,lon (link)
@@ -450,6 +455,9 @@ function showInfo() {
             "success": 0,
             "failure": 0,
             "idle": 0,
+            "unchanged": 0,
+            "rejected": 0,
+            "skipped": 0,
             "success rate": 0.0
         };
         scrapes.forEach(function (scrape) {
@@ -521,9 +529,10 @@ function createTimelapseImages() {
 
     // fill it up
     scrapes.forEach(function (scrape) {
-        if (scrape["result"] == "success") {
+        if (scrape["result"] == "success" && scrape["file"]) {
+            // the first thumbnail (if any) loads faster than the full image
             var img = document.createElement("img");
-            img.src = scrape["file"];
+            img.src = scrape["thumbs"] ? scrape["thumbs"][0]["file"] : scrape["file"];
             img.classList.add("hidden");
             tldisp.appendChild(img);
         }
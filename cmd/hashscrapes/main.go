@@ -0,0 +1,85 @@
+// special program created to compute the perceptual hashes of the images
+// of scrapes made before scrape hashes were stored in the mtcam database.
+// It can be stopped and run again; only scrapes without a hash are read.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"os"
+
+	"github.com/disintegration/imaging"
+
+	"github.com/quillaja/mtcam/config"
+	"github.com/quillaja/mtcam/db"
+	"github.com/quillaja/mtcam/pipeline"
+	"github.com/quillaja/mtcam/storage"
+)
+
+func main() {
+	cfgPath := flag.String("cfg", "", "path to suite config (required)")
+	batch := flag.Int("batch", 1000, "number of scrapes read from the db at a time")
+	flag.Parse()
+	if *cfgPath == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var cfg config.SuiteConfig
+	kill(config.Read(*cfgPath, &cfg))
+	images, err := cfg.OpenStorage()
+	kill(err)
+	kill(db.Connect(cfg.DatabaseConnection))
+	defer db.Close()
+
+	mts, err := db.Mountains()
+	kill(err)
+	cams, err := db.Cameras()
+	kill(err)
+
+	fmt.Printf("hashing scrapes in %s\n\n", images)
+	var hashed, skipped, afterID int
+	for {
+		scrapes, err := db.UnhashedScrapes(afterID, *batch)
+		kill(err)
+		if len(scrapes) == 0 {
+			break
+		}
+
+		for _, s := range scrapes {
+			afterID = s.ID
+			cam := cams[s.CameraID]
+			mt := mts[cam.MountainID]
+			img, err := open(images, storage.Key(mt.Pathname, cam.Pathname, s.Filename))
+			if err != nil {
+				fmt.Printf("\rskipping scrape(id=%d): %s\n", s.ID, err)
+				skipped++
+				continue
+			}
+			kill(db.SetScrapeHash(s.ID, pipeline.FormatHash(pipeline.DHash(img))))
+			hashed++
+		}
+
+		// status display
+		fmt.Printf("\r hashed through rowid %-10d\r", afterID)
+	}
+
+	fmt.Printf("\nfinished hashing. %d scrapes hashed, %d skipped.\n", hashed, skipped)
+}
+
+// open decodes the image stored as key.
+func open(images storage.Storage, key string) (image.Image, error) {
+	r, err := images.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return imaging.Decode(r)
+}
+
+func kill(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/quillaja/mtcam/db"
+	"github.com/quillaja/mtcam/log"
+	"github.com/quillaja/mtcam/scheduler"
+)
+
+// content type header value for json
+const jsonMime = "application/json"
+
+// prefix of an admin address which is a unix socket path
+const unixPrefix = "unix:"
+
+// listenAdmin listens on addr, which is either a TCP address (eg
+// localhost:8081) or a unix socket path prefixed by "unix:". A TCP address
+// which isn't loopback (including one without a host, eg ":8081") is an
+// error unless allowRemote is set, since the admin server has no auth.
+func listenAdmin(addr string, allowRemote bool) (net.Listener, error) {
+	path := strings.TrimPrefix(addr, unixPrefix)
+	if path == addr {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if !isLoopback(host) {
+			if !allowRemote {
+				return nil, errors.Errorf("%s isn't a loopback address (see AdminAllowRemote)", addr)
+			}
+			log.Printf(log.Warning, "admin server on %s is reachable from the network without auth", addr)
+		}
+		return net.Listen("tcp", addr)
+	}
+
+	// remove a socket left behind if scraped wasn't shut down cleanly
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// only the owner and group can administer scraped
+	return l, os.Chmod(path, 0660)
+}
+
+// isLoopback reports if host is localhost or a loopback IP address.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// startAdmin starts the admin server on the configured address. It does
+// nothing if no address is configured.
+func (app *Application) startAdmin() error {
+	addr := app.config().AdminAddress
+	if addr == "" {
+		return nil
+	}
+
+	l, err := listenAdmin(addr, app.config().AdminAllowRemote)
+	if err != nil {
+		return errors.Wrapf(err, "listening on admin address %s", addr)
+	}
+	app.admin = &http.Server{Handler: app.adminHandler()}
+	go func() {
+		err := app.admin.Serve(l)
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf(log.Critical, "error with admin server: %s", err)
+		}
+	}()
+
+	log.Printf(log.Info, "admin listening on %s", addr)
+	return nil
+}
+
+// adminHandler creates the handler for the admin server.
+//
+//	GET  /queue                    all queued, waiting and running tasks
+//	GET  /running                  running tasks
+//	GET  /cameras                  next scrape of each camera
+//	POST /cameras/<id>/scrape      scrape the camera now
+//	POST /cameras/<id>/pause       skip the camera's scrapes until resumed
+//	POST /cameras/<id>/resume      stop skipping the camera's scrapes
+//	POST /mountains/<id>/reschedule  plan the rest of the mountain's day again
+//	GET  /retention                images which retention policies would prune now
+//	GET  /disk                     free space of the images' and database's volumes
+//
+// /queue and /running can be filtered by tag, eg /queue?kind=scrape&mountain=3.
+// /retention can be filtered by camera, eg /retention?camera=3
+func (app *Application) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/queue", adminGet(app.adminQueue))
+	mux.HandleFunc("/running", adminGet(app.adminRunning))
+	mux.HandleFunc("/cameras", adminGet(app.adminCameras))
+	mux.HandleFunc("/cameras/", adminPost(`^/cameras/(\d+)/(scrape|pause|resume)$`, app.adminCamera))
+	mux.HandleFunc("/mountains/", adminPost(`^/mountains/(\d+)/(reschedule)$`, app.adminMountain))
+	mux.HandleFunc("/retention", adminGet(app.adminRetention))
+	mux.HandleFunc("/disk", adminGet(app.adminDisk))
+	return mux
+}
+
+// adminError is an error with the HTTP status to respond with.
+type adminError struct {
+	status int
+	err    error
+}
+
+func (e adminError) Error() string { return e.err.Error() }
+
+// adminFunc handles an admin request, returning the value to encode as
+// the json response.
+type adminFunc func(r *http.Request) (interface{}, error)
+
+// adminGet returns a HandlerFunc which responds to GET requests with the
+// result of f.
+func adminGet(f adminFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			adminRespond(w, r, nil, adminError{http.StatusMethodNotAllowed, errors.New("use GET")})
+			return
+		}
+		v, err := f(r)
+		adminRespond(w, r, v, err)
+	}
+}
+
+// adminPost returns a HandlerFunc which responds to POST requests to paths
+// matching expression, which must have 2 groups: an id and an action.
+func adminPost(expression string, f func(id int, action string) (interface{}, error)) http.HandlerFunc {
+	re := regexp.MustCompile(expression)
+	return func(w http.ResponseWriter, r *http.Request) {
+		matches := re.FindStringSubmatch(r.URL.Path)
+		if matches == nil {
+			adminRespond(w, r, nil, adminError{http.StatusNotFound, errors.New("not found")})
+			return
+		}
+		if r.Method != http.MethodPost {
+			adminRespond(w, r, nil, adminError{http.StatusMethodNotAllowed, errors.New("use POST")})
+			return
+		}
+		id, _ := strconv.Atoi(matches[1])
+		v, err := f(id, matches[2])
+		adminRespond(w, r, v, err)
+	}
+}
+
+// adminRespond writes v, or err as {"error": "..."}, as json and logs
+// the request.
+func adminRespond(w http.ResponseWriter, r *http.Request, v interface{}, err error) {
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusInternalServerError
+		if ae, ok := err.(adminError); ok {
+			status = ae.status
+		}
+		v = map[string]string{"error": err.Error()}
+	}
+	log.Printf(log.Info, "admin %s %s %d %s", r.Method, r.URL.Path, status, http.StatusText(status))
+	if err != nil {
+		log.Printf(log.Warning, "admin %s %s: %s", r.Method, r.URL.Path, err)
+	}
+
+	w.Header().Set(contenttype, jsonMime)
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	enc.Encode(v)
+}
+
+// adminQueueResponse is the response to /queue.
+type adminQueueResponse struct {
+	Now     time.Time         `json:"now"`
+	Next    *time.Time        `json:"next"` // null if nothing is queued
+	Queued  int               `json:"queued"`
+	Waiting int               `json:"waiting"`
+	Running int               `json:"running"`
+	Tasks   []scheduler.Entry `json:"tasks"`
+}
+
+func (app *Application) adminQueue(r *http.Request) (interface{}, error) {
+	resp := adminQueueResponse{
+		Now:   app.Clock.Now(),
+		Tasks: filterEntries(app.Scheduler.List(), r.URL.Query().Get)}
+	for i, e := range resp.Tasks {
+		switch e.State {
+		case scheduler.Queued:
+			if resp.Next == nil {
+				resp.Next = &resp.Tasks[i].When
+			}
+			resp.Queued++
+		case scheduler.Waiting:
+			resp.Waiting++
+		case scheduler.Running:
+			resp.Running++
+		}
+	}
+	return resp, nil
+}
+
+func (app *Application) adminRunning(r *http.Request) (interface{}, error) {
+	var running []scheduler.Entry
+	for _, e := range filterEntries(app.Scheduler.List(), r.URL.Query().Get) {
+		if e.State == scheduler.Running {
+			running = append(running, e)
+		}
+	}
+	if running == nil {
+		running = []scheduler.Entry{} // encode as [], not null
+	}
+	return running, nil
+}
+
+// filterEntries returns the entries whose tags match the value returned by
+// query for each of scraped's tags. An empty value matches any tag.
+func filterEntries(entries []scheduler.Entry, query func(string) string) []scheduler.Entry {
+	filtered := entries[:0]
+outer:
+	for _, e := range entries {
+		for _, tag := range []string{tagKind, tagMountain, tagCamera} {
+			if v := query(tag); v != "" && e.Tags[tag] != v {
+				continue outer
+			}
+		}
+		filtered = append(filtered, e)
+	}
+	return filtered
+}
+
+// adminCamera is an entry in the response to /cameras.
+type adminCamera struct {
+	ID         int        `json:"id"`
+	MountainID int        `json:"mountain_id"`
+	Next       *time.Time `json:"next"`    // time of next queued scrape. null if none
+	Queued     int        `json:"queued"`  // number of queued scrapes
+	Running    bool       `json:"running"` // a scrape is running now
+	Paused     bool       `json:"paused"`
+}
+
+func (app *Application) adminCameras(r *http.Request) (interface{}, error) {
+	cams := make(map[int]*adminCamera)
+	get := func(camID int) *adminCamera {
+		if cams[camID] == nil {
+			cams[camID] = &adminCamera{ID: camID, Paused: app.isPaused(camID)}
+		}
+		return cams[camID]
+	}
+
+	for _, e := range app.Scheduler.List() {
+		if e.Tags[tagKind] != kindScrape {
+			continue
+		}
+		camID, err := strconv.Atoi(e.Tags[tagCamera])
+		if err != nil {
+			continue
+		}
+		cam := get(camID)
+		cam.MountainID, _ = strconv.Atoi(e.Tags[tagMountain])
+		switch e.State {
+		case scheduler.Running:
+			cam.Running = true
+		case scheduler.Queued:
+			if cam.Next == nil || e.When.Before(*cam.Next) {
+				when := e.When
+				cam.Next = &when
+			}
+			cam.Queued++
+		}
+	}
+	// paused cameras are listed even if nothing is queued for them
+	for _, camID := range app.pausedCameras() {
+		get(camID)
+	}
+
+	list := make([]adminCamera, 0, len(cams))
+	for _, cam := range cams {
+		list = append(list, *cam)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// adminAction is the response to a POST request.
+type adminAction struct {
+	Action  string       `json:"action"`
+	Task    scheduler.ID `json:"task,omitempty"`    // id of the task added
+	Removed int          `json:"removed,omitempty"` // number of tasks removed
+}
+
+func (app *Application) adminCamera(camID int, action string) (interface{}, error) {
+	cam, err := db.Camera(camID)
+	if err != nil {
+		return nil, notFound(err, "camera", camID)
+	}
+
+	switch action {
+	case "scrape":
+		if app.isPaused(camID) {
+			return nil, adminError{http.StatusConflict, errors.Errorf("camera %d is paused", camID)}
+		}
+		id := app.Scheduler.Add(newScrapeTask(app.Clock.Now(), cam.MountainID, camID, app))
+		log.Printf(log.Info, "(mtID=%d camID=%d) scrape requested by admin", cam.MountainID, camID)
+		return adminAction{Action: action, Task: id}, nil
+
+	case "pause", "resume":
+		app.pause(camID, action == "pause")
+		log.Printf(log.Info, "(mtID=%d camID=%d) %sd by admin", cam.MountainID, camID, action)
+		return adminAction{Action: action}, nil
+	}
+	return nil, adminError{http.StatusNotFound, errors.Errorf("unknown action %s", action)}
+}
+
+func (app *Application) adminMountain(mtID int, action string) (interface{}, error) {
+	if _, err := db.Mountain(mtID); err != nil {
+		return nil, notFound(err, "mountain", mtID)
+	}
+
+	// the task replaces the mountain's queued scrapes with the rest
+	// of today's, and is retried if it fails like the daily task
+	id := app.Scheduler.Add(newScheduleTask(app.Clock.Now(), mtID, app))
+	log.Printf(log.Info, "(mtID=%d) reschedule requested by admin", mtID)
+	return adminAction{Action: action, Task: id}, nil
+}
+
+// adminRetention reports what the retention policies would prune now,
+// without pruning anything.
+func (app *Application) adminRetention(r *http.Request) (interface{}, error) {
+	var camID int
+	if v := r.URL.Query().Get(tagCamera); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, adminError{http.StatusBadRequest, errors.Errorf("invalid camera %q", v)}
+		}
+		if _, err = db.Camera(id); err != nil {
+			return nil, notFound(err, "camera", id)
+		}
+		camID = id
+	}
+	return applyRetention(app.config(), app.Clock.Now(), true, camID)
+}
+
+// adminDisk checks the free space of the volumes guarded by scraped. A
+// volume's state is "full" if scrapes are being skipped.
+func (app *Application) adminDisk(r *http.Request) (interface{}, error) {
+	cfg := app.config()
+	return checkDisk(cfg.Disk, guardedVolumes(cfg), app.Clock.Now(), diskSpace), nil
+}
+
+// notFound converts err reading kind with id from the db to an adminError,
+// which is 404 if the row doesn't exist.
+func notFound(err error, kind string, id int) error {
+	if errors.Cause(err) == sql.ErrNoRows {
+		return adminError{http.StatusNotFound, errors.Errorf("no %s %d", kind, id)}
+	}
+	return err
+}
+
+// pause sets whether camID is paused. Scrapes of a paused camera stay
+// queued but are skipped when they're due. Pauses aren't saved, so all
+// cameras are resumed when scraped restarts.
+func (app *Application) pause(camID int, paused bool) {
+	app.pauseMutex.Lock()
+	defer app.pauseMutex.Unlock()
+
+	if !paused {
+		delete(app.paused, camID)
+		return
+	}
+	if app.paused == nil {
+		app.paused = make(map[int]bool)
+	}
+	app.paused[camID] = true
+}
+
+// isPaused reports if camID is paused.
+func (app *Application) isPaused(camID int) bool {
+	app.pauseMutex.Lock()
+	defer app.pauseMutex.Unlock()
+	return app.paused[camID]
+}
+
+// pausedCameras gets the ids of paused cameras.
+func (app *Application) pausedCameras() []int {
+	app.pauseMutex.Lock()
+	defer app.pauseMutex.Unlock()
+
+	ids := make([]int, 0, len(app.paused))
+	for id := range app.paused {
+		ids = append(ids, id)
+	}
+	return ids
+}
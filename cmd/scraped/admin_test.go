@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quillaja/mtcam/scheduler"
+)
+
+// TestAdminQueue checks the admin server's read-only endpoints, which
+// don't need the database.
+func TestAdminQueue(t *testing.T) {
+	now := time.Date(2026, 6, 1, 6, 0, 0, 0, time.UTC)
+	clock := scheduler.NewFakeClock(now)
+	app := &Application{
+		Config:    &ScrapedConfig{},
+		Clock:     clock,
+		Scheduler: scheduler.NewScheduler(scheduler.WithClock(clock))}
+
+	scrape := func(when time.Time, mtID, camID string) scheduler.Task {
+		return scheduler.NewTaggedTask(when,
+			scheduler.Tags{tagKind: kindScrape, tagMountain: mtID, tagCamera: camID},
+			func(time.Time) {})
+	}
+	app.Scheduler.Add(scrape(now.Add(10*time.Minute), "1", "2"))
+	app.Scheduler.Add(scrape(now.Add(5*time.Minute), "1", "2"))
+	app.Scheduler.Add(scrape(now.Add(time.Minute), "3", "4"))
+	app.Scheduler.Add(scheduler.NewTaggedTask(now.Add(time.Hour),
+		scheduler.Tags{tagKind: kindSchedule, tagMountain: "1"}, func(time.Time) {}))
+	app.pause(5, true)
+
+	server := httptest.NewServer(app.adminHandler())
+	defer server.Close()
+	get := func(path string, v interface{}) int {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if v != nil && resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode
+	}
+
+	var queue adminQueueResponse
+	get("/queue", &queue)
+	if queue.Queued != 4 || len(queue.Tasks) != 4 || queue.Next == nil || !queue.Next.Equal(now.Add(time.Minute)) {
+		t.Errorf("got queue %+v", queue)
+	}
+	get("/queue?mountain=1&kind=scrape", &queue)
+	if queue.Queued != 2 || !queue.Next.Equal(now.Add(5*time.Minute)) {
+		t.Errorf("got filtered queue %+v", queue)
+	}
+
+	var cams []adminCamera
+	get("/cameras", &cams)
+	want := []adminCamera{
+		{ID: 2, MountainID: 1, Queued: 2},
+		{ID: 4, MountainID: 3, Queued: 1},
+		{ID: 5, Paused: true}}
+	if len(cams) != len(want) {
+		t.Fatalf("got cameras %+v, want %+v", cams, want)
+	}
+	for i, cam := range cams {
+		next := cam.Next
+		cam.Next = nil
+		if cam != want[i] {
+			t.Errorf("camera %d is %+v, want %+v", i, cam, want[i])
+		}
+		if (next == nil) != (cam.Queued == 0) {
+			t.Errorf("camera %d next scrape is %v with %d queued", cam.ID, next, cam.Queued)
+		}
+	}
+	if !cams[0].Next.Equal(now.Add(5 * time.Minute)) {
+		t.Errorf("camera 2 next scrape at %s, want %s", cams[0].Next, now.Add(5*time.Minute))
+	}
+
+	var running []scheduler.Entry
+	if get("/running", &running); len(running) != 0 {
+		t.Errorf("%d running, want 0", len(running))
+	}
+
+	if status := get("/cameras/2/pause", nil); status != http.StatusMethodNotAllowed {
+		t.Errorf("GET of action got status %d", status)
+	}
+	if status := get("/cameras/x/pause", nil); status != http.StatusNotFound {
+		t.Errorf("bad camera id got status %d", status)
+	}
+}
+
+func TestListenAdmin(t *testing.T) {
+	tests := []struct {
+		addr        string
+		allowRemote bool
+		ok          bool
+	}{
+		{"127.0.0.1:0", false, true},
+		{"localhost:0", false, true},
+		{"[::1]:0", false, true},
+		{":0", false, false},
+		{"0.0.0.0:0", false, false},
+		{"192.0.2.1:0", false, false},
+		{":0", true, true},
+		{"8081", false, false},
+	}
+	for _, tt := range tests {
+		l, err := listenAdmin(tt.addr, tt.allowRemote)
+		if l != nil {
+			l.Close()
+		}
+		if tt.ok && err != nil && !strings.Contains(err.Error(), "cannot assign") {
+			t.Errorf("listen on %s (allow remote %t): %s", tt.addr, tt.allowRemote, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("listened on %s (allow remote %t)", tt.addr, tt.allowRemote)
+		}
+	}
+}
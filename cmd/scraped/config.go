@@ -2,34 +2,84 @@ package main
 
 import (
 	"github.com/quillaja/mtcam/config"
+	"github.com/quillaja/mtcam/model"
 )
 
 // ScrapedConfig holds settings for the scraped executable.
+//
+// The config is reloaded when its file changes (checked every ConfigWatchSec
+// seconds, or never if 0) or scraped receives SIGHUP. Fields tagged
+// `config:"restart"` only change when scraped is restarted.
 type ScrapedConfig struct {
 	config.SuiteConfig `json:"-"`
 
-	SuiteConfigPath string
-	ConfigWatchSec  int
+	SuiteConfigPath string `config:"restart"`
+	ConfigWatchSec  int    `config:"restart"`
 
 	UserAgent         string
 	RequestTimeoutSec int
+	// max size in bytes of a downloaded image. 0 is unlimited.
+	MaxDownloadBytes int64
 
 	GoogleTzAPIKey string
 
+	// secrets (eg passwords) used by the auth of cameras' request specs,
+	// by name. a basic auth secret is "user:password".
+	Secrets map[string]string
+
+	// address of the admin server, either a TCP address (eg localhost:8081)
+	// or a unix socket path prefixed by "unix:". empty disables the server.
+	AdminAddress string `config:"restart"`
+	// the admin server is unauthenticated, so a TCP AdminAddress must be
+	// a loopback address (eg localhost or 127.0.0.1) unless this is set.
+	AdminAllowRemote bool `config:"restart"`
+
 	Image Image
 
 	Scheduling Scheduling
 
+	Health Health
+
+	Retention Retention
+
+	Disk Disk
+
 	// astro max tries?
 }
 
 // Image holds settings related to processing scraped images.
+//
+// Each camera can override some of them with its image settings (see
+// model.ImageSettings).
 type Image struct {
-	Width             int
-	Height            int
-	Quality           int
-	EqualityTesting   bool
+	Width   int
+	Height  int
+	Quality int
+	// format (file extension, eg "jpg") of saved images. empty is each
+	// camera's file extension.
+	Format string
+	// downloaded images smaller than this are rejected. 0 is no minimum.
+	MinWidth  int
+	MinHeight int
+	// images with a lower mean (MinBrightness) or standard deviation
+	// (MinContrast) of luminance (0-255) are rejected, eg black frames at
+	// night or solid "offline" cards. 0 doesn't reject images.
+	MinBrightness float64
+	MinContrast   float64
+	// storage keys of placeholder images (eg "camera offline") which are
+	// rejected. usually set in a camera's image settings instead.
+	Placeholders []string
+	// reject images which are the same as a recent image, comparing
+	// perceptual hashes (the dedupe stage)
+	EqualityTesting bool
+	// max color difference of the same pixel in identical images, used
+	// by the compare stage
 	EqualityTolerance float64
+	// number of recent images compared with each new image. 0 is 1
+	HashHistory int
+	// max number of bits which differ in the hashes of identical images,
+	// including images matching a camera's placeholders
+	HashDistance int
 }
 
 // Scheduling holds settings related to scheduling tasks.
@@ -38,4 +88,62 @@ type Scheduling struct {
 	MaxAttempts int
 	// wait time between attempts to schedule a day's worth of scrapes?
 	WaitTime int //mins?
+	// seconds between saves of the task queue to the db so it can be
+	// restored when scraped restarts. 0 disables saving the queue.
+	PersistSec int `config:"restart"`
+	// max number of tasks (eg scrapes) running at once. 0 is unlimited.
+	Workers int `config:"restart"`
+	// max number of scrapes running at once against the same host.
+	// 0 is unlimited.
+	PerHost int `config:"restart"`
+	// scrapes overdue by more than this many seconds (eg after a suspend
+	// or restart) are skipped. only the latest overdue scrape of each
+	// camera is run regardless. 0 doesn't skip scrapes for being overdue.
+	MissedScrapeSec int
+	// max tries of each scrape which fails to download. 0 or 1 doesn't
+	// retry failed scrapes.
+	ScrapeAttempts int
+	// seconds to wait before retrying a failed scrape. the wait doubles
+	// after each failed retry.
+	ScrapeBackoffSec int
+	// failed scrapes aren't retried later than this many seconds after
+	// they were due. 0 doesn't limit when a scrape is retried.
+	ScrapeRetryUntilSec int
+}
+
+// Health holds settings related to tracking the health of cameras. A
+// threshold of 0 disables the state.
+type Health struct {
+	// consecutive failed or duplicate scrapes before a camera is degraded
+	DegradedAfter int
+	// consecutive duplicate scrapes before a camera is frozen
+	FrozenAfter int
+	// consecutive failed (not duplicate) scrapes before a camera is down
+	DownAfter int
+	// minutes between scrapes of a frozen camera until it recovers. 0 (or
+	// less than the camera's interval) scrapes it as usual.
+	FrozenIntervalMin int
+}
+
+// Retention holds settings related to pruning old images.
+type Retention struct {
+	// cron expression (see scheduler.Cron), in local time, of when images
+	// are pruned, eg "30 3 * * *". empty never prunes images.
+	Schedule string `config:"restart"`
+	// policy of cameras without their own (see model.RetentionPolicy).
+	// the zero policy keeps every image.
+	Policy model.RetentionPolicy
+	// log what would be pruned without removing any images
+	DryRun bool
+}
+
+// Disk holds settings related to guarding the free space of the volumes
+// holding the images (if stored locally) and the database. A threshold of
+// 0 disables it.
+type Disk struct {
+	// below this many MB free, an error is logged and images are pruned
+	// (see Retention) at most hourly
+	SoftFreeMB int64
+	// below this many MB free, scrapes are skipped (recorded as skipped)
+	HardFreeMB int64
 }
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/quillaja/mtcam/log"
+	"github.com/quillaja/mtcam/pipeline"
+	"github.com/quillaja/mtcam/scheduler"
+	"github.com/quillaja/mtcam/storage"
+)
+
+// States of the disk space guard.
+const (
+	diskOK   = "ok"
+	diskLow  = "low"  // below the soft threshold
+	diskFull = "full" // below the hard threshold
+)
+
+// lowSpacePruneInterval is the minimum time between prunes triggered by
+// low disk space.
+const lowSpacePruneInterval = time.Hour
+
+// diskState is the outcome of checking the free space of the volumes
+// guarded by scraped.
+type diskState struct {
+	Checked time.Time    `json:"checked"`
+	State   string       `json:"state"` // the worst state of the volumes
+	Volumes []diskVolume `json:"volumes"`
+}
+
+// diskVolume is the free space of a volume guarded by scraped.
+type diskVolume struct {
+	Name  string `json:"name"` // "images" or "database"
+	Path  string `json:"path"`
+	Free  uint64 `json:"free_bytes"`
+	Total uint64 `json:"total_bytes"`
+	State string `json:"state"`
+	Error string `json:"error,omitempty"` // the space couldn't be checked
+}
+
+// String describes the volumes which aren't ok.
+func (d diskState) String() string {
+	var low []string
+	for _, v := range d.Volumes {
+		if v.State != diskOK {
+			low = append(low, fmt.Sprintf("%s (%s) has %d MB free", v.Name, v.Path, v.Free>>20))
+		}
+	}
+	if len(low) == 0 {
+		return "enough space free"
+	}
+	return strings.Join(low, ", ")
+}
+
+// guardedVolumes returns the volumes guarded by scraped: the image root if
+// images are stored locally, and the database's directory if it's a file.
+func guardedVolumes(cfg *ScrapedConfig) []diskVolume {
+	var volumes []diskVolume
+	if images, err := cfg.OpenStorage(); err == nil {
+		if local, ok := images.(storage.Local); ok {
+			volumes = append(volumes, diskVolume{Name: "images", Path: local.Root})
+		}
+	}
+
+	// eg "file:/var/opt/mtcam/mtcam.db?_busy_timeout=5000"
+	dbPath := strings.TrimPrefix(cfg.DatabaseConnection, "file:")
+	if i := strings.IndexByte(dbPath, '?'); i >= 0 {
+		dbPath = dbPath[:i]
+	}
+	if dbPath != "" && dbPath != ":memory:" {
+		volumes = append(volumes, diskVolume{Name: "database", Path: filepath.Dir(dbPath)})
+	}
+	return volumes
+}
+
+// checkDisk checks the free space of volumes at now, using space to get the
+// free and total bytes of a path. A path which doesn't exist yet (eg the
+// image root before the first scrape) is checked at its closest existing
+// parent.
+func checkDisk(cfg Disk, volumes []diskVolume, now time.Time, space func(string) (free, total uint64, err error)) diskState {
+	state := diskState{Checked: now, State: diskOK, Volumes: volumes}
+	for i := range state.Volumes {
+		v := &state.Volumes[i]
+		v.State = diskOK
+
+		var err error
+		for path := v.Path; ; path = filepath.Dir(path) {
+			v.Free, v.Total, err = space(path)
+			if !os.IsNotExist(err) || path == filepath.Dir(path) {
+				break
+			}
+		}
+		if err != nil {
+			v.Error = err.Error()
+			continue
+		}
+
+		free := int64(v.Free >> 20)
+		switch {
+		case cfg.HardFreeMB > 0 && free < cfg.HardFreeMB:
+			v.State = diskFull
+		case cfg.SoftFreeMB > 0 && free < cfg.SoftFreeMB:
+			v.State = diskLow
+		}
+		if v.State == diskFull || (v.State == diskLow && state.State == diskOK) {
+			state.State = v.State
+		}
+	}
+	return state
+}
+
+// guardDisk checks the free space of the guarded volumes before a scrape
+// at now. Below the soft threshold, images are pruned (at most every
+// lowSpacePruneInterval). Below the hard threshold, it returns an error
+// which skips the scrape.
+func (app *Application) guardDisk(now time.Time) error {
+	cfg := app.config()
+	if cfg.Disk.SoftFreeMB <= 0 && cfg.Disk.HardFreeMB <= 0 {
+		return nil
+	}
+	state := checkDisk(cfg.Disk, guardedVolumes(cfg), now, diskSpace)
+	for _, v := range state.Volumes {
+		if v.Error != "" {
+			log.Printf(log.Warning, "couldn't check free space of %s (%s): %s", v.Name, v.Path, v.Error)
+		}
+	}
+
+	app.diskMutex.Lock()
+	previous := app.diskState
+	app.diskState = state.State
+	prune := state.State != diskOK && now.Sub(app.lowSpacePrune) >= lowSpacePruneInterval
+	if prune {
+		app.lowSpacePrune = now
+	}
+	app.diskMutex.Unlock()
+
+	if state.State != previous {
+		switch state.State {
+		case diskFull:
+			log.Printf(log.Critical, "disk nearly full, skipping scrapes: %s", state)
+		case diskLow:
+			log.Printf(log.Error, "disk space low: %s", state)
+		case diskOK:
+			if previous != "" {
+				log.Printf(log.Info, "disk space recovered: %s", state)
+			}
+		}
+	}
+	if prune {
+		log.Printf(log.Error, "pruning images to free disk space: %s", state)
+		app.Scheduler.Add(scheduler.NewFallibleTask(now, scheduler.Tags{tagKind: kindPrune}, PruneImages(app)))
+	}
+
+	if state.State == diskFull {
+		return pipeline.Skip(pipeline.ReasonDiskFull, state.String())
+	}
+	return nil
+}
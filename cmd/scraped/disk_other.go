@@ -0,0 +1,10 @@
+// +build !linux,!darwin
+
+package main
+
+import "github.com/pkg/errors"
+
+// diskSpace isn't supported on this platform.
+func diskSpace(path string) (free, total uint64, err error) {
+	return 0, 0, errors.New("checking disk space isn't supported")
+}
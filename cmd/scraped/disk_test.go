@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestGuardedVolumes(t *testing.T) {
+	cfg := &ScrapedConfig{}
+	cfg.ImageRoot = "/var/opt/mtcam/img"
+	cfg.DatabaseConnection = "file:/var/opt/mtcam/db/mtcam.db?_busy_timeout=5000"
+	want := []diskVolume{
+		{Name: "images", Path: "/var/opt/mtcam/img"},
+		{Name: "database", Path: "/var/opt/mtcam/db"}}
+	if got := guardedVolumes(cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// images in s3 and an in-memory db aren't on a local volume
+	cfg.Storage.Driver = "s3"
+	cfg.Storage.Endpoint, cfg.Storage.Bucket = "http://localhost:9000", "mtcam"
+	cfg.DatabaseConnection = ":memory:"
+	if got := guardedVolumes(cfg); len(got) != 0 {
+		t.Errorf("got %+v, want none", got)
+	}
+}
+
+func TestCheckDisk(t *testing.T) {
+	const mb = 1 << 20
+	free := map[string]uint64{"/img": 500 * mb, "/db": 50 * mb, "/full": 5 * mb}
+	space := func(path string) (uint64, uint64, error) {
+		if path == "/broken" {
+			return 0, 0, errors.New("broken")
+		}
+		f, ok := free[path]
+		if !ok {
+			return 0, 0, os.ErrNotExist
+		}
+		return f, 1000 * mb, nil
+	}
+	cfg := Disk{SoftFreeMB: 100, HardFreeMB: 10}
+	now := time.Unix(1565257200, 0)
+
+	tests := []struct {
+		paths  []string
+		states []string
+		state  string
+	}{
+		{[]string{"/img"}, []string{diskOK}, diskOK},
+		{[]string{"/img/mt_hood/new"}, []string{diskOK}, diskOK}, // checks /img
+		{[]string{"/img", "/db"}, []string{diskOK, diskLow}, diskLow},
+		{[]string{"/full", "/db"}, []string{diskFull, diskLow}, diskFull},
+		{[]string{"/broken", "/img"}, []string{diskOK, diskOK}, diskOK},
+	}
+	for _, tt := range tests {
+		var volumes []diskVolume
+		for _, path := range tt.paths {
+			volumes = append(volumes, diskVolume{Name: path, Path: path})
+		}
+		got := checkDisk(cfg, volumes, now, space)
+		var states []string
+		for _, v := range got.Volumes {
+			states = append(states, v.State)
+		}
+		if got.State != tt.state || !reflect.DeepEqual(states, tt.states) {
+			t.Errorf("%v: got %s %v, want %s %v", tt.paths, got.State, states, tt.state, tt.states)
+		}
+	}
+
+	// the thresholds are disabled by 0
+	got := checkDisk(Disk{}, []diskVolume{{Path: "/full"}}, now, space)
+	if got.State != diskOK {
+		t.Errorf("disabled thresholds got %s", got.State)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/quillaja/mtcam/db"
+	"github.com/quillaja/mtcam/log"
+	"github.com/quillaja/mtcam/model"
+	"github.com/quillaja/mtcam/pipeline"
+)
+
+// nextHealth returns cam with its Duplicates, Failures, and Health updated
+// by the outcome of a scrape at now, which either succeeded, was a
+// duplicate, was rejected as unusable (eg dark at night), or otherwise
+// failed. A rejected image doesn't change the counts, since the camera
+// is working. HealthChanged is set to now if the camera's Health changes.
+func nextHealth(cam model.Camera, success, duplicate, rejected bool, cfg Health, now time.Time) model.Camera {
+	switch {
+	case success:
+		cam.Duplicates, cam.Failures = 0, 0
+	case rejected:
+	case duplicate:
+		cam.Duplicates, cam.Failures = cam.Duplicates+1, 0
+	default:
+		cam.Duplicates, cam.Failures = 0, cam.Failures+1
+	}
+
+	health := model.Healthy
+	switch {
+	case reached(cam.Failures, cfg.DownAfter):
+		health = model.Down
+	case reached(cam.Duplicates, cfg.FrozenAfter):
+		health = model.Frozen
+	case reached(cam.Duplicates+cam.Failures, cfg.DegradedAfter):
+		health = model.Degraded
+	}
+	if health != cam.Health {
+		cam.Health = health
+		cam.HealthChanged = now
+	}
+	return cam
+}
+
+// reached reports if n has reached threshold. A threshold of 0 is never
+// reached.
+func reached(n, threshold int) bool {
+	return threshold > 0 && n >= threshold
+}
+
+// updateHealth updates the health of the camera of s in the db after its
+// pipeline ran, returning err. A change of health is logged.
+func (app *Application) updateHealth(s *pipeline.Scrape, err error) {
+	// scrapes of the camera finishing together (eg a retry overlapping the
+	// next scrape) update its counts one at a time, so none are lost
+	lock := app.healthLock(s.Camera.ID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// read the camera again so that the counts include any scrapes which
+	// finished while s was running
+	cam, dberr := db.Camera(s.Camera.ID)
+	if dberr != nil {
+		log.Printf(log.Error, "%s couldn't read camera to update health: %s", s, dberr)
+		return
+	}
+
+	// a camera showing its placeholder (eg "camera offline") is stuck, so
+	// it counts as a duplicate rather than a dark or blank image
+	placeholder := pipeline.Reason(err) == pipeline.ReasonPlaceholder
+	next := nextHealth(cam,
+		s.Record.Result == model.Success,
+		pipeline.IsDuplicate(err) || placeholder,
+		pipeline.IsRejected(err) && !placeholder,
+		app.config().Health,
+		app.Clock.Now())
+	if next.Health != cam.Health {
+		log.Printf(log.Warning, "%s camera %s is now %s (%d duplicates, %d failures)",
+			s, cam.Name, next.Health, next.Duplicates, next.Failures)
+	}
+	if dberr = db.SetCameraHealth(next); dberr != nil {
+		log.Printf(log.Error, "%s %s", s, dberr)
+	}
+}
+
+// healthLock gets the lock held while the health of camID is updated.
+func (app *Application) healthLock(camID int) *sync.Mutex {
+	app.healthMutex.Lock()
+	defer app.healthMutex.Unlock()
+	if app.healthLocks == nil {
+		app.healthLocks = make(map[int]*sync.Mutex)
+	}
+	lock, ok := app.healthLocks[camID]
+	if !ok {
+		lock = new(sync.Mutex)
+		app.healthLocks[camID] = lock
+	}
+	return lock
+}
+
+// skipFrozen reports if the scrape of cam at now should be skipped because
+// cam is frozen and its previous (failed or unchanged) scrape was less than
+// FrozenIntervalMin minutes before now.
+func skipFrozen(cam model.Camera, now time.Time, cfg Health) bool {
+	interval := time.Duration(cfg.FrozenIntervalMin) * time.Minute
+	if cam.Health != model.Frozen || interval <= time.Duration(cam.Interval)*time.Minute {
+		return false
+	}
+	for _, result := range []string{model.Failure, model.Unchanged} {
+		prev, err := db.MostRecentScrape(cam.ID, result)
+		if err == nil && now.Sub(prev.Created) < interval {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quillaja/mtcam/model"
+)
+
+// TestNextHealth runs a camera through a sequence of scrape outcomes,
+// checking its health after each.
+func TestNextHealth(t *testing.T) {
+	const (
+		ok        = "ok"
+		duplicate = "duplicate"
+		failure   = "failure"
+		rejected  = "rejected"
+	)
+	cfg := Health{DegradedAfter: 2, FrozenAfter: 4, DownAfter: 3}
+	steps := []struct {
+		outcome string
+		want    string
+	}{
+		{duplicate, model.Healthy},
+		{duplicate, model.Degraded},
+		{duplicate, model.Degraded},
+		{duplicate, model.Frozen},
+		{duplicate, model.Frozen},
+		{failure, model.Healthy}, // failures reset the duplicate count
+		{failure, model.Degraded},
+		{failure, model.Down},
+		{duplicate, model.Healthy},
+		{failure, model.Healthy},
+		{rejected, model.Healthy}, // rejects (eg dark at night) don't count
+		{rejected, model.Healthy},
+		{rejected, model.Healthy},
+		{failure, model.Degraded},
+		{rejected, model.Degraded}, // nor do they reset the counts
+		{failure, model.Down},
+		{ok, model.Healthy},
+	}
+
+	cam := model.Camera{ID: 1, Health: model.Healthy}
+	start := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i, step := range steps {
+		now := start.Add(time.Duration(i) * time.Minute)
+		prev := cam
+		cam = nextHealth(cam, step.outcome == ok, step.outcome == duplicate, step.outcome == rejected, cfg, now)
+		if cam.Health != step.want {
+			t.Fatalf("step %d (%s): health %s, want %s", i, step.outcome, cam.Health, step.want)
+		}
+		if changed := cam.Health != prev.Health; changed != cam.HealthChanged.Equal(now) {
+			t.Errorf("step %d (%s): health changed at %s", i, step.outcome, cam.HealthChanged)
+		}
+	}
+	if cam.Duplicates != 0 || cam.Failures != 0 {
+		t.Errorf("after success got %d duplicates, %d failures", cam.Duplicates, cam.Failures)
+	}
+
+	// a threshold of 0 disables the state
+	cam = model.Camera{Health: model.Healthy, Duplicates: 100}
+	if cam = nextHealth(cam, false, true, false, Health{}, start); cam.Health != model.Healthy {
+		t.Errorf("health %s with no thresholds", cam.Health)
+	}
+}
@@ -3,10 +3,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
@@ -41,20 +46,11 @@ func main() {
 	}
 
 	// read and create config
-	var cfg ScrapedConfig
-	err := config.Read(*configPath, &cfg)
+	cfg, err := readConfig(*configPath)
 	if err != nil {
-		log.Printf(log.Error, "could read config %s: %s", *configPath, err)
+		log.Print(log.Error, err)
 		return
 	}
-	err = config.Read(cfg.SuiteConfigPath, &cfg.SuiteConfig)
-	if err != nil {
-		log.Printf(log.Error, "could read suit config %s: %s", cfg.SuiteConfigPath, err)
-		return
-	}
-	// TODO: watch config file(s) and update config on the fly.
-	// What about options that can't be (easily) changed once the program is
-	// running, such as DB connection, and config watch interval?
 
 	// 'connect' to database
 	err = db.Connect(cfg.DatabaseConnection)
@@ -68,9 +64,42 @@ func main() {
 	taskwait := 30 * time.Second
 	app := &Application{
 		Config: &cfg,
-		Scheduler: scheduler.NewScheduler(
-			scheduler.WaitForUnfinishedTasks(taskwait)),
+		Clock:  scheduler.SystemClock}
+	options := []scheduler.Option{
+		scheduler.WithClock(app.Clock),
+		scheduler.WaitForUnfinishedTasks(taskwait),
+		scheduler.ReportSkipped(func(e scheduler.Entry, reason string) {
+			log.Printf(log.Warning, "skipped %s task (mtID=%s camID=%s) due at %s: %s",
+				e.Tags[tagKind], e.Tags[tagMountain], e.Tags[tagCamera],
+				e.When.Format(time.UnixDate), reason)
+		}),
+		scheduler.ReportResults(func(r scheduler.Result) {
+			if r.Err == nil {
+				return
+			}
+			if r.Retry.IsZero() {
+				log.Printf(log.Warning, "attempt %d of %s task (mtID=%s camID=%s) due at %s failed, not retrying: %s",
+					r.Attempt, r.Entry.Tags[tagKind], r.Entry.Tags[tagMountain], r.Entry.Tags[tagCamera],
+					r.Entry.When.Format(time.UnixDate), r.Err)
+				return
+			}
+			log.Printf(log.Warning, "attempt %d of %s task (mtID=%s camID=%s) due at %s failed, retrying at %s: %s",
+				r.Attempt, r.Entry.Tags[tagKind], r.Entry.Tags[tagMountain], r.Entry.Tags[tagCamera],
+				r.Entry.When.Format(time.UnixDate), r.Retry.Format(time.UnixDate), r.Err)
+		})}
+	if cfg.Scheduling.PersistSec > 0 {
+		options = append(options, scheduler.PersistTo(
+			taskStore{},
+			time.Duration(cfg.Scheduling.PersistSec)*time.Second,
+			taskFactories(app)))
 	}
+	if cfg.Scheduling.Workers > 0 {
+		options = append(options, scheduler.Workers(cfg.Scheduling.Workers))
+	}
+	if cfg.Scheduling.PerHost > 0 {
+		options = append(options, scheduler.LimitPerKey(cfg.Scheduling.PerHost, app.hostKey))
+	}
+	app.Scheduler = scheduler.NewScheduler(options...)
 
 	log.Printf(log.Info, "starting scrape daemon %s", version.Version)
 	err = app.run()
@@ -78,12 +107,20 @@ func main() {
 		log.Printf(log.Critical, "error running application: %s", err)
 		return
 	}
+	err = app.startAdmin()
+	if err != nil {
+		log.Printf(log.Error, "admin server not started: %s", err)
+	}
+	stopWatching := app.watchConfig(*configPath)
+	defer stopWatching()
 
-	// wait for os signals to end app
-	sig := make(chan os.Signal)
-	signal.Notify(sig, os.Kill, os.Interrupt, syscall.SIGTERM)
+	// wait for os signals to end app, reloading the config on SIGHUP
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Kill, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
-	<-sig
+	for s := <-sig; s == syscall.SIGHUP; s = <-sig {
+		app.reloadConfig(*configPath)
+	}
 
 	log.Printf(log.Info, "waiting %s for %d tasks to complete...", taskwait, app.Scheduler.Running())
 	app.shutdown() // will wait 30 sec for unfinished tasks to complete
@@ -93,10 +130,34 @@ func main() {
 
 // Application is the scraped app.
 type Application struct {
-	Config    *ScrapedConfig
+	// Config is replaced when the config is reloaded, so it's read
+	// with config() once the app is running
+	Config      *ScrapedConfig
+	configMutex sync.RWMutex
+
 	Scheduler *scheduler.Scheduler
+	Clock     scheduler.Clock // also used by Scheduler
 
 	cancel context.CancelFunc
+	admin  *http.Server
+
+	// paused cameras, by camID
+	paused     map[int]bool
+	pauseMutex sync.Mutex
+
+	// locks held while each camera's health is updated, by camID
+	healthLocks map[int]*sync.Mutex
+	healthMutex sync.Mutex
+
+	// host of each camera's url, by camID
+	hosts     map[int]string
+	hostMutex sync.Mutex
+
+	// state of the disk space guard when it was last checked, and when
+	// low space last triggered pruning
+	diskState     string
+	lowSpacePrune time.Time
+	diskMutex     sync.Mutex
 }
 
 // run starts the scheduler, adds tasks to schedule scrapes, and blocks.
@@ -106,23 +167,123 @@ func (app *Application) run() error {
 	ctx, app.cancel = context.WithCancel(context.Background())
 	app.Scheduler.Start(ctx)
 
+	// restore tasks saved when scraped last stopped. mountains with
+	// a restored daily ScheduleScrapes task already have their day planned.
+	planned := make(map[int]bool)
+	restored, err := app.Scheduler.Restore()
+	if err != nil {
+		log.Printf(log.Error, "restoring saved tasks: %s", err)
+	}
+	for _, rec := range restored {
+		if rec.Kind != kindSchedule {
+			continue
+		}
+		var args scheduleArgs
+		if json.Unmarshal(rec.Args, &args) == nil && args.Location != "" {
+			planned[args.MountainID] = true
+		}
+	}
+	if len(restored) > 0 {
+		log.Printf(log.Info, "restored %d saved tasks", len(restored))
+	}
+
 	// load scheduler with some tasks
 	mts, err := db.Mountains()
 	if err != nil {
 		return errors.Wrap(err, "reading db in app.run()")
 	}
-	for id := range mts {
-		app.Scheduler.Add(scheduler.NewTask(
-			time.Now(),
-			ScheduleScrapes(id, 0, app)))
+	for id, mt := range mts {
+		if planned[id] {
+			continue
+		}
+		tz, err := time.LoadLocation(mt.TzLocation)
+		if err != nil {
+			log.Printf(log.Error, "(mtID=%d) can't load timezone, scheduling daily in UTC: %s", id, err)
+			tz = time.UTC
+		}
+		app.Scheduler.Add(newDailyScheduleTask(app.Clock.Now(), id, tz, app))
+	}
+
+	// prune old images on the retention schedule
+	if expr := app.config().Retention.Schedule; expr != "" {
+		schedule, err := scheduler.Cron(expr, time.Local)
+		if err != nil {
+			return errors.Wrap(err, "retention schedule")
+		}
+		app.Scheduler.Add(newPruneTask(schedule.Next(app.Clock.Now()), schedule, app))
 	}
 
 	return nil
 }
 
 func (app *Application) shutdown() {
+	if app.admin != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := app.admin.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			log.Printf(log.Error, "error shutting down admin server: %s", err)
+		}
+	}
+
 	app.cancel()
 
 	// block on scheduler
 	app.Scheduler.Wait()
 }
+
+// hostKey returns the host of the camera scraped by t, which is used to
+// limit the number of scrapes to each host running at once. Tasks other
+// than scrapes have no key.
+func (app *Application) hostKey(t scheduler.Task) string {
+	tagged, ok := t.(scheduler.Tagged)
+	if !ok || tagged.Tags()[tagKind] != kindScrape {
+		return ""
+	}
+	camID, err := strconv.Atoi(tagged.Tags()[tagCamera])
+	if err != nil {
+		return ""
+	}
+	return app.cameraHost(camID)
+}
+
+// cameraHost gets the host of camID's url, reading the camera from the
+// db the first time it is needed.
+func (app *Application) cameraHost(camID int) string {
+	app.hostMutex.Lock()
+	defer app.hostMutex.Unlock()
+
+	if host, ok := app.hosts[camID]; ok {
+		return host
+	}
+
+	cam, err := db.Camera(camID)
+	if err != nil {
+		log.Printf(log.Error, "(camID=%d) couldn't read camera to get host: %s", camID, err)
+		return ""
+	}
+	rawurl, err := cam.ExecuteUrl(UrlData{Camera: cam, Now: app.Clock.Now()})
+	if err != nil {
+		log.Printf(log.Error, "(camID=%d) couldn't get host: %s", camID, err)
+		return ""
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		log.Printf(log.Error, "(camID=%d) couldn't parse url to get host: %s", camID, err)
+		return ""
+	}
+
+	if app.hosts == nil {
+		app.hosts = make(map[int]string)
+	}
+	app.hosts[camID] = u.Hostname()
+	return u.Hostname()
+}
+
+// forgetHost removes camID's host from the cache so it will be read from
+// the db the next time it's needed.
+func (app *Application) forgetHost(camID int) {
+	app.hostMutex.Lock()
+	defer app.hostMutex.Unlock()
+	delete(app.hosts, camID)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/quillaja/mtcam/db"
+	"github.com/quillaja/mtcam/log"
+	"github.com/quillaja/mtcam/model"
+	"github.com/quillaja/mtcam/scheduler"
+)
+
+// Kinds of persistent tasks scheduled by scraped.
+const (
+	kindScrape   = "scrape"
+	kindSchedule = "schedule"
+)
+
+// Tags attached to scraped's tasks.
+const (
+	tagKind     = "kind"
+	tagMountain = "mountain"
+	tagCamera   = "camera"
+)
+
+// scrapeArgs are the arguments saved for a Scrape task.
+type scrapeArgs struct {
+	MountainID int
+	CameraID   int
+}
+
+// scheduleArgs are the arguments saved for a ScheduleScrapes task.
+type scheduleArgs struct {
+	MountainID int
+	// Location, if set, makes the task recur daily at midnight in Location
+	Location string `json:",omitempty"`
+}
+
+// newScrapeTask creates a persistent task which scrapes camID at when.
+// If overdue, only the latest scrape of each camera is run, and scrapes
+// older than the configured limit are skipped. Failed scrapes are retried
+// with exponential backoff as configured.
+func newScrapeTask(when time.Time, mtID, camID int, app *Application) scheduler.Task {
+	t := scheduler.NewPersistentTask(
+		when,
+		kindScrape,
+		scrapeArgs{MountainID: mtID, CameraID: camID},
+		scheduler.Tags{
+			tagKind:     kindScrape,
+			tagMountain: strconv.Itoa(mtID),
+			tagCamera:   strconv.Itoa(camID)},
+		Scrape(mtID, camID, app))
+
+	cfg := app.config().Scheduling
+	t = scheduler.WithMissedPolicy(t, scheduler.MissedPolicy{
+		MaxAge: time.Duration(cfg.MissedScrapeSec) * time.Second,
+		Key:    cameraKey})
+
+	retry := scheduler.ExponentialBackoff(cfg.ScrapeAttempts,
+		time.Duration(cfg.ScrapeBackoffSec)*time.Second)
+	retry.Until = time.Duration(cfg.ScrapeRetryUntilSec) * time.Second
+	return scheduler.WithRetryPolicy(t, retry)
+}
+
+// cameraKey gets the camera tag of t.
+func cameraKey(t scheduler.Task) string {
+	if tagged, ok := t.(scheduler.Tagged); ok {
+		return tagged.Tags()[tagCamera]
+	}
+	return ""
+}
+
+// newScheduleTask creates a persistent task which schedules the scrapes
+// for mtID once at when.
+func newScheduleTask(when time.Time, mtID int, app *Application) scheduler.Task {
+	t := scheduler.NewPersistentTask(
+		when,
+		kindSchedule,
+		scheduleArgs{MountainID: mtID},
+		scheduler.Tags{
+			tagKind:     kindSchedule,
+			tagMountain: strconv.Itoa(mtID)},
+		ScheduleScrapes(mtID, app))
+	return scheduler.WithRetryPolicy(t, scheduleRetry(app))
+}
+
+// newDailyScheduleTask creates a persistent task which schedules the scrapes
+// for mtID at when, and then daily at midnight in the mountain's timezone tz.
+func newDailyScheduleTask(when time.Time, mtID int, tz *time.Location, app *Application) scheduler.Task {
+	t := scheduler.NewPersistentTask(
+		when,
+		kindSchedule,
+		scheduleArgs{MountainID: mtID, Location: tz.String()},
+		scheduler.Tags{
+			tagKind:     kindSchedule,
+			tagMountain: strconv.Itoa(mtID)},
+		ScheduleScrapes(mtID, app))
+	t = scheduler.WithRetryPolicy(t, scheduleRetry(app))
+	return scheduler.Repeat(t, scheduler.Midnight(tz))
+}
+
+// scheduleRetry is the policy for retrying a failed attempt to schedule a
+// mountain's scrapes: up to MaxAttempts more tries, WaitTime minutes apart.
+func scheduleRetry(app *Application) scheduler.RetryPolicy {
+	cfg := app.config().Scheduling
+	return scheduler.RetryPolicy{
+		MaxAttempts: cfg.MaxAttempts + 1,
+		Backoff:     time.Duration(cfg.WaitTime) * time.Minute}
+}
+
+// taskFactories returns the factories used to recreate scraped's tasks
+// when they're restored from the database.
+func taskFactories(app *Application) map[string]scheduler.Factory {
+	return map[string]scheduler.Factory{
+		kindScrape: func(rec scheduler.Record) (scheduler.Task, error) {
+			var args scrapeArgs
+			if err := json.Unmarshal(rec.Args, &args); err != nil {
+				return nil, errors.Wrap(err, "unmarshaling scrape args")
+			}
+			return newScrapeTask(rec.When, args.MountainID, args.CameraID, app), nil
+		},
+
+		kindSchedule: func(rec scheduler.Record) (scheduler.Task, error) {
+			var args scheduleArgs
+			if err := json.Unmarshal(rec.Args, &args); err != nil {
+				return nil, errors.Wrap(err, "unmarshaling schedule args")
+			}
+			if args.Location != "" {
+				tz, err := time.LoadLocation(args.Location)
+				if err != nil {
+					return nil, errors.Wrap(err, "loading daily schedule location")
+				}
+				return newDailyScheduleTask(rec.When, args.MountainID, tz, app), nil
+			}
+			return newScheduleTask(rec.When, args.MountainID, app), nil
+		},
+	}
+}
+
+// taskStore implements scheduler.Store using the database.
+type taskStore struct{}
+
+func (taskStore) SaveTasks(recs []scheduler.Record) error {
+	tasks := make([]model.PendingTask, len(recs))
+	for i, rec := range recs {
+		tasks[i] = model.PendingTask{
+			Kind:     rec.Kind,
+			Args:     string(rec.Args),
+			Due:      rec.When,
+			Attempt:  rec.Attempt,
+			FirstDue: rec.First}
+	}
+
+	err := db.ReplacePendingTasks(tasks)
+	if err != nil {
+		// the scheduler has no way to report the error, so log it here
+		log.Printf(log.Error, "couldn't save %d pending tasks: %s", len(tasks), err)
+	}
+	return err
+}
+
+func (taskStore) LoadTasks() ([]scheduler.Record, error) {
+	tasks, err := db.PendingTasks()
+	if err != nil {
+		return nil, err
+	}
+
+	recs := make([]scheduler.Record, len(tasks))
+	for i, t := range tasks {
+		recs[i] = scheduler.Record{
+			Kind:    t.Kind,
+			Args:    []byte(t.Args),
+			When:    t.Due,
+			Attempt: t.Attempt,
+			First:   t.FirstDue}
+	}
+	return recs, nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+
+	"github.com/quillaja/mtcam/db"
+	"github.com/quillaja/mtcam/log"
+	"github.com/quillaja/mtcam/model"
+	"github.com/quillaja/mtcam/pipeline"
+	"github.com/quillaja/mtcam/storage"
+)
+
+// Names of the stages which can be used in a camera's pipeline.
+const (
+	stageFetch    = "fetch"
+	stageMJPEG    = "mjpeg"
+	stageValidate = "validate"
+	stageDecode   = "decode"
+	stageInspect  = "inspect"
+	stageResize   = "resize"
+	stageCrop     = "crop"
+	stageHash     = "hash"
+	stageDedupe   = "dedupe"
+	stageCompare  = "compare"
+	stageWrite    = "write"
+	stageThumb    = "thumbnail"
+)
+
+// recorder saves the scrape record at the end of every pipeline.
+var recorder = pipeline.StageFunc("record", func(s *pipeline.Scrape) error {
+	return db.InsertScrape(&s.Record)
+})
+
+// cameraPipeline builds the pipeline which scrapes cam, which is defined
+// by the cam's Pipeline, or defaultDefinition() if it has none. The cam's
+// image settings are merged over cfg.Image (see cameraConfig()).
+func cameraPipeline(cam model.Camera, cfg *ScrapedConfig) (*pipeline.Pipeline, error) {
+	settings, err := cam.ImageSettings()
+	if err != nil {
+		return nil, err
+	}
+	if settings.Format != "" {
+		if _, err = imaging.FormatFromExtension(settings.Format); err != nil {
+			return nil, errors.Wrapf(err, "image format %q", settings.Format)
+		}
+	}
+	cfg = cameraConfig(cfg, settings)
+	st, err := cfg.OpenStorage()
+	if err != nil {
+		return nil, err
+	}
+
+	def, err := pipeline.ParseDefinition(cam.Pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if def == nil {
+		def = defaultDefinition(cfg, settings.Crop)
+	}
+	return pipeline.Build(def, stageFactories(cfg, st), recorder)
+}
+
+// cameraConfig returns a copy of cfg with the non-zero image settings of
+// a camera merged over cfg.Image.
+func cameraConfig(cfg *ScrapedConfig, settings model.ImageSettings) *ScrapedConfig {
+	merged := *cfg
+	img := &merged.Image
+	if settings.Width > 0 {
+		img.Width = settings.Width
+	}
+	if settings.Height > 0 {
+		img.Height = settings.Height
+	}
+	if settings.Quality > 0 {
+		img.Quality = settings.Quality
+	}
+	if settings.Format != "" {
+		img.Format = settings.Format
+	}
+	if settings.Tolerance != nil {
+		img.EqualityTolerance = *settings.Tolerance
+	}
+	if settings.HashDistance != nil {
+		img.HashDistance = *settings.HashDistance
+	}
+	if settings.MinBrightness != nil {
+		img.MinBrightness = *settings.MinBrightness
+	}
+	if settings.MinContrast != nil {
+		img.MinContrast = *settings.MinContrast
+	}
+	if settings.Placeholders != nil {
+		img.Placeholders = settings.Placeholders
+	}
+	return &merged
+}
+
+// defaultDefinition is the pipeline of cameras without their own: fetch,
+// validate, decode, inspect, crop (if crop isn't nil), resize, hash, dedupe
+// (if equality testing is on), write, and thumbnail (if thumbnails are
+// configured).
+func defaultDefinition(cfg *ScrapedConfig, crop *model.CropRect) pipeline.Definition {
+	def := pipeline.Definition{
+		{Stage: stageFetch},
+		{Stage: stageValidate},
+		{Stage: stageDecode},
+		{Stage: stageInspect}}
+	if crop != nil {
+		params, _ := json.Marshal(pipeline.Crop{X: crop.X, Y: crop.Y, Width: crop.Width, Height: crop.Height})
+		def = append(def, pipeline.StageDef{Stage: stageCrop, Params: params})
+	}
+	def = append(def,
+		pipeline.StageDef{Stage: stageResize},
+		pipeline.StageDef{Stage: stageHash})
+	if cfg.Image.EqualityTesting {
+		def = append(def, pipeline.StageDef{Stage: stageDedupe})
+	}
+	def = append(def, pipeline.StageDef{Stage: stageWrite})
+	if len(cfg.Thumbnails) > 0 {
+		def = append(def, pipeline.StageDef{Stage: stageThumb})
+	}
+	return def
+}
+
+// stageFactories returns the factories of the stages which can be used in
+// a camera's pipeline. The params of each stage default to the settings
+// in cfg. Images are stored in st.
+func stageFactories(cfg *ScrapedConfig, st storage.Storage) map[string]pipeline.Factory {
+	return map[string]pipeline.Factory{
+		stageFetch: func(params json.RawMessage) (pipeline.Stage, error) {
+			p := struct {
+				UserAgent     string
+				TimeoutSec    int
+				Unconditional bool
+				MaxBytes      int64
+			}{UserAgent: cfg.UserAgent, TimeoutSec: cfg.RequestTimeoutSec, MaxBytes: cfg.MaxDownloadBytes}
+			err := pipeline.DecodeParams(params, &p)
+			return pipeline.Fetch{
+				UserAgent:     p.UserAgent,
+				Timeout:       time.Duration(p.TimeoutSec) * time.Second,
+				Unconditional: p.Unconditional,
+				Secrets:       cfg.Secrets,
+				MaxSize:       p.MaxBytes}, err
+		},
+
+		stageMJPEG: func(params json.RawMessage) (pipeline.Stage, error) {
+			p := struct {
+				UserAgent   string
+				TimeoutSec  int
+				Frame       int
+				DeadlineSec int
+			}{UserAgent: cfg.UserAgent, TimeoutSec: cfg.RequestTimeoutSec}
+			err := pipeline.DecodeParams(params, &p)
+			return pipeline.MJPEG{
+				Fetch: pipeline.Fetch{
+					UserAgent: p.UserAgent,
+					Timeout:   time.Duration(p.TimeoutSec) * time.Second,
+					Secrets:   cfg.Secrets},
+				Frame:    p.Frame,
+				Deadline: time.Duration(p.DeadlineSec) * time.Second}, err
+		},
+
+		stageValidate: func(params json.RawMessage) (pipeline.Stage, error) {
+			v := pipeline.Validate{MinWidth: cfg.Image.MinWidth, MinHeight: cfg.Image.MinHeight}
+			err := pipeline.DecodeParams(params, &v)
+			return v, err
+		},
+
+		stageDecode: func(params json.RawMessage) (pipeline.Stage, error) {
+			return pipeline.Decode{}, nil
+		},
+
+		stageInspect: func(params json.RawMessage) (pipeline.Stage, error) {
+			in := pipeline.Inspect{
+				MinBrightness:       cfg.Image.MinBrightness,
+				MinContrast:         cfg.Image.MinContrast,
+				Placeholders:        cfg.Image.Placeholders,
+				PlaceholderDistance: cfg.Image.HashDistance}
+			err := pipeline.DecodeParams(params, &in)
+			in.Storage = st
+			return in, err
+		},
+
+		stageResize: func(params json.RawMessage) (pipeline.Stage, error) {
+			r := pipeline.Resize{Width: cfg.Image.Width, Height: cfg.Image.Height}
+			err := pipeline.DecodeParams(params, &r)
+			return r, err
+		},
+
+		stageCrop: func(params json.RawMessage) (pipeline.Stage, error) {
+			var c pipeline.Crop
+			err := pipeline.DecodeParams(params, &c)
+			return c, err
+		},
+
+		stageHash: func(params json.RawMessage) (pipeline.Stage, error) {
+			return pipeline.Hash{}, nil
+		},
+
+		stageDedupe: func(params json.RawMessage) (pipeline.Stage, error) {
+			p := struct{ History, MaxDistance int }{cfg.Image.HashHistory, cfg.Image.HashDistance}
+			err := pipeline.DecodeParams(params, &p)
+			if p.History < 1 {
+				p.History = 1
+			}
+			return pipeline.Dedupe{
+				Recent:      recentHashes(p.History),
+				MaxDistance: p.MaxDistance}, err
+		},
+
+		stageCompare: func(params json.RawMessage) (pipeline.Stage, error) {
+			p := struct{ Tolerance float64 }{cfg.Image.EqualityTolerance}
+			err := pipeline.DecodeParams(params, &p)
+			return pipeline.Compare{
+				Previous:  previousImage(st),
+				Tolerance: p.Tolerance,
+				Quality:   cfg.Image.Quality}, err
+		},
+
+		stageWrite: func(params json.RawMessage) (pipeline.Stage, error) {
+			w := pipeline.Write{Quality: cfg.Image.Quality, Format: cfg.Image.Format}
+			err := pipeline.DecodeParams(params, &w)
+			w.Storage = st
+			return w, err
+		},
+
+		stageThumb: func(params json.RawMessage) (pipeline.Stage, error) {
+			t := pipeline.Thumbnail{Sizes: cfg.Thumbnails}
+			err := pipeline.DecodeParams(params, &t)
+			t.Storage = st
+			return t, err
+		},
+	}
+}
+
+// saveValidators saves the validators of the image fetched by s, which
+// finished with err, so that the camera's next fetch is conditional. They're
+// only saved if the image was written or was a duplicate, so that an image
+// which failed for another reason (eg couldn't be decoded) is fetched again.
+func saveValidators(s *pipeline.Scrape, err error) {
+	if s.Header == nil || !(s.Record.Result == model.Success || pipeline.IsDuplicate(err)) {
+		return
+	}
+	cam := s.Camera
+	cam.ETag, cam.LastModified = s.Validators()
+	if cam.ETag == s.Camera.ETag && cam.LastModified == s.Camera.LastModified {
+		return
+	}
+	if err := db.SetCameraValidators(cam); err != nil {
+		log.Printf(log.Error, "%s %s", s, err)
+	}
+}
+
+// recentHashes returns a function which gets the hashes of the n most
+// recent successful scrapes of a scrape's camera.
+func recentHashes(n int) func(*pipeline.Scrape) ([]string, error) {
+	return func(s *pipeline.Scrape) ([]string, error) {
+		return db.RecentHashes(s.Camera.ID, n)
+	}
+}
+
+// previousImage returns a function which opens the most recently
+// (successfully) scraped image of a scrape's camera, in st.
+func previousImage(st storage.Storage) func(*pipeline.Scrape) (image.Image, error) {
+	return func(s *pipeline.Scrape) (image.Image, error) {
+		prev, err := db.MostRecentScrape(s.Camera.ID, model.Success)
+		if err != nil {
+			return nil, err
+		}
+		r, err := st.Get(storage.Key(s.Mountain.Pathname, s.Camera.Pathname, prev.Filename))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return imaging.Decode(r)
+	}
+}
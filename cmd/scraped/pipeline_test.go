@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/quillaja/mtcam/model"
+	"github.com/quillaja/mtcam/pipeline"
+	"github.com/quillaja/mtcam/storage"
+)
+
+func TestCameraPipeline(t *testing.T) {
+	cfg := &ScrapedConfig{Image: Image{
+		Width: 1280, Height: 720, Quality: 80,
+		MinBrightness: 10, MinContrast: 5,
+		EqualityTesting: true, HashDistance: 2}}
+	cfg.ImageRoot = "images"
+
+	names := func(p *pipeline.Pipeline) (names []string) {
+		for _, s := range p.Stages {
+			names = append(names, s.Name())
+		}
+		return
+	}
+	stage := func(p *pipeline.Pipeline, name string) pipeline.Stage {
+		for _, s := range p.Stages {
+			if s.Name() == name {
+				return s
+			}
+		}
+		t.Fatalf("no %s stage in %v", name, names(p))
+		return nil
+	}
+
+	// the defaults
+	p, err := cameraPipeline(model.Camera{}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"fetch", "validate", "decode", "inspect", "resize", "hash", "dedupe", "write"}
+	if !reflect.DeepEqual(names(p), want) {
+		t.Errorf("default stages %v, want %v", names(p), want)
+	}
+	if r := stage(p, "resize").(pipeline.Resize); r.Width != 1280 || r.Height != 720 {
+		t.Errorf("default resize %+v", r)
+	}
+
+	// image settings are merged over the config
+	cam := model.Camera{Image: `{"width": 3840, "quality": 95, "format": "png",
+		"crop": {"y": 40}, "hash_distance": 0}`}
+	p, err = cameraPipeline(cam, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"fetch", "validate", "decode", "inspect", "crop", "resize", "hash", "dedupe", "write"}
+	if !reflect.DeepEqual(names(p), want) {
+		t.Errorf("stages %v, want %v", names(p), want)
+	}
+	if c := stage(p, "crop").(pipeline.Crop); c != (pipeline.Crop{Y: 40}) {
+		t.Errorf("crop %+v", c)
+	}
+	if r := stage(p, "resize").(pipeline.Resize); r.Width != 3840 || r.Height != 720 {
+		t.Errorf("resize %+v", r)
+	}
+	if w := stage(p, "write").(pipeline.Write); w.Quality != 95 || w.Format != "png" {
+		t.Errorf("write %+v", w)
+	}
+	if d := stage(p, "dedupe").(pipeline.Dedupe); d.MaxDistance != 0 {
+		t.Errorf("dedupe max distance %d, want 0", d.MaxDistance)
+	}
+	if cfg.Image.Width != 1280 || cfg.Image.HashDistance != 2 {
+		t.Errorf("config modified: %+v", cfg.Image)
+	}
+
+	// inspect settings too, keeping the rest of the default pipeline
+	cam = model.Camera{Image: `{"crop": {"y": 40}, "min_brightness": 0,
+		"placeholders": ["hood/palmer/offline.jpg"]}`}
+	p, err = cameraPipeline(cam, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"fetch", "validate", "decode", "inspect", "crop", "resize", "hash", "dedupe", "write"}
+	if !reflect.DeepEqual(names(p), want) {
+		t.Errorf("stages with inspect settings %v, want %v", names(p), want)
+	}
+	in := stage(p, "inspect").(pipeline.Inspect)
+	if in.MinBrightness != 0 || in.MinContrast != 5 || in.PlaceholderDistance != 2 ||
+		!reflect.DeepEqual(in.Placeholders, []string{"hood/palmer/offline.jpg"}) {
+		t.Errorf("inspect %+v", in)
+	}
+
+	for _, image := range []string{`{"format": "webp"}`, `{"width": "wide"}`} {
+		if _, err := cameraPipeline(model.Camera{Image: image}, cfg); err == nil {
+			t.Errorf("built pipeline with image settings %s", image)
+		}
+	}
+
+	// thumbnails follow the write stage when they're configured
+	cfg.Thumbnails = []model.Thumbnail{{Name: "small", Width: 320}}
+	p, err = cameraPipeline(model.Camera{}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"fetch", "validate", "decode", "inspect", "resize", "hash", "dedupe", "write", "thumbnail"}
+	if !reflect.DeepEqual(names(p), want) {
+		t.Errorf("stages with thumbnails %v, want %v", names(p), want)
+	}
+	if th := stage(p, "thumbnail").(pipeline.Thumbnail); !reflect.DeepEqual(th.Sizes, cfg.Thumbnails) {
+		t.Errorf("thumbnail sizes %+v", th.Sizes)
+	}
+
+	// images are written to the configured storage
+	if w := stage(p, "write").(pipeline.Write); w.Storage != (storage.Local{Root: "images"}) {
+		t.Errorf("write storage %#v", w.Storage)
+	}
+	cfg.Storage.Driver = "ftp"
+	if _, err := cameraPipeline(model.Camera{}, cfg); err == nil {
+		t.Error("built pipeline with unknown storage driver")
+	}
+}
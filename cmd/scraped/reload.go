@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/quillaja/mtcam/config"
+	"github.com/quillaja/mtcam/log"
+	"github.com/quillaja/mtcam/model"
+)
+
+// readConfig reads the scraped config at path and the suite config it
+// refers to.
+func readConfig(path string) (cfg ScrapedConfig, err error) {
+	err = config.Read(path, &cfg)
+	if err != nil {
+		return cfg, errors.Wrapf(err, "could read config %s", path)
+	}
+	err = config.Read(cfg.SuiteConfigPath, &cfg.SuiteConfig)
+	if err != nil {
+		return cfg, errors.Wrapf(err, "could read suite config %s", cfg.SuiteConfigPath)
+	}
+	if _, err = cfg.OpenStorage(); err != nil {
+		return cfg, errors.Wrapf(err, "invalid storage in suite config %s", cfg.SuiteConfigPath)
+	}
+	if err = cfg.Retention.Policy.Validate(); err != nil {
+		return cfg, errors.Wrapf(err, "invalid config %s", path)
+	}
+	if err = validateThumbnails(cfg.Thumbnails); err != nil {
+		return cfg, errors.Wrapf(err, "invalid thumbnails in suite config %s", cfg.SuiteConfigPath)
+	}
+	return cfg, nil
+}
+
+// validateThumbnails returns an error if a thumbnail is invalid or 2 have
+// the same name, which would be the same file.
+func validateThumbnails(thumbnails []model.Thumbnail) error {
+	names := make(map[string]bool)
+	for _, t := range thumbnails {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+		if names[t.Name] {
+			return errors.Errorf("duplicate thumbnail name %q", t.Name)
+		}
+		names[t.Name] = true
+	}
+	return nil
+}
+
+// config gets the current config, which must not be modified.
+func (app *Application) config() *ScrapedConfig {
+	app.configMutex.RLock()
+	defer app.configMutex.RUnlock()
+	return app.Config
+}
+
+// reloadConfig reads the config at path again and replaces the current
+// config with it. Settings which can't be changed without restarting
+// scraped keep their current value and are logged. The current config is
+// kept if the new one can't be read.
+func (app *Application) reloadConfig(path string) {
+	cfg, err := readConfig(path)
+	if err != nil {
+		log.Printf(log.Error, "keeping current config: %s", err)
+		return
+	}
+
+	app.configMutex.Lock()
+	restart := config.RestartRequired(app.Config, &cfg)
+	app.Config = &cfg
+	app.configMutex.Unlock()
+
+	log.Printf(log.Info, "reloaded config %s", path)
+	if len(restart) > 0 {
+		log.Printf(log.Warning, "restart required to change %s", strings.Join(restart, ", "))
+	}
+}
+
+// watchConfig reloads the config when the file at path, or the suite config
+// it refers to, changes. It does nothing if ConfigWatchSec is 0. The
+// returned function stops watching.
+func (app *Application) watchConfig(path string) context.CancelFunc {
+	cfg := app.config()
+	if cfg.ConfigWatchSec <= 0 {
+		return func() {}
+	}
+
+	freq := time.Duration(cfg.ConfigWatchSec) * time.Second
+	changed := func(filename string, err error) {
+		if err != nil {
+			log.Printf(log.Error, "watching config %s: %s", filename, err)
+			return
+		}
+		log.Printf(log.Info, "config %s changed", filename)
+		app.reloadConfig(path)
+	}
+	stopConfig := config.Watch(path, freq, changed)
+	stopSuite := config.Watch(cfg.SuiteConfigPath, freq, changed)
+
+	return func() {
+		stopConfig()
+		stopSuite()
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scraped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "scraped.json")
+	suite := filepath.Join(dir, "suite.json")
+	write := func(filename, json string) {
+		t.Helper()
+		if err := ioutil.WriteFile(filename, []byte(json), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(suite, `{"DatabaseConnection": "a.db", "ImageRoot": "img"}`)
+	write(path, `{"SuiteConfigPath": "`+suite+`", "UserAgent": "old", "Scheduling": {"Workers": 2}}`)
+
+	cfg, err := readConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &Application{Config: &cfg}
+
+	write(suite, `{"DatabaseConnection": "b.db", "ImageRoot": "img2"}`)
+	write(path, `{"SuiteConfigPath": "`+suite+`", "UserAgent": "new", "Scheduling": {"Workers": 4}}`)
+	app.reloadConfig(path)
+
+	got := app.config()
+	if got.UserAgent != "new" || got.ImageRoot != "img2" {
+		t.Errorf("live settings not reloaded: %+v", got)
+	}
+	if got.DatabaseConnection != "a.db" || got.Scheduling.Workers != 2 {
+		t.Errorf("restart required settings changed: %+v", got)
+	}
+
+	// a config which can't be read is ignored
+	write(path, `{`)
+	app.reloadConfig(path)
+	if app.config() != got {
+		t.Error("config replaced by unreadable config")
+	}
+}
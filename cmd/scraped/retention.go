@@ -0,0 +1,209 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/quillaja/mtcam/db"
+	"github.com/quillaja/mtcam/log"
+	"github.com/quillaja/mtcam/model"
+	"github.com/quillaja/mtcam/scheduler"
+	"github.com/quillaja/mtcam/storage"
+)
+
+// kindPrune is the kind of the task which prunes old images. It isn't
+// persistent; it's added when scraped starts.
+const kindPrune = "prune"
+
+// retentionReport is the outcome of applying retention policies, or what
+// it would be in a dry run.
+type retentionReport struct {
+	Now     time.Time         `json:"now"`
+	DryRun  bool              `json:"dry_run"`
+	Pruned  int               `json:"pruned"` // number of images (which would be) removed
+	Bytes   int64             `json:"bytes"`  // recorded size of the removed images
+	Cameras []retentionCamera `json:"cameras"`
+}
+
+// retentionCamera is the outcome of applying a camera's retention policy.
+type retentionCamera struct {
+	ID         int      `json:"id"`
+	MountainID int      `json:"mountain_id"`
+	Kept       int      `json:"kept"`
+	Pruned     int      `json:"pruned"`
+	Bytes      int64    `json:"bytes"`
+	Files      []string `json:"files,omitempty"` // keys of the removed images, in dry runs
+	Error      string   `json:"error,omitempty"`
+}
+
+// newPruneTask creates a task which prunes old images (see PruneImages)
+// at when, and then on schedule.
+func newPruneTask(when time.Time, schedule scheduler.Schedule, app *Application) scheduler.Task {
+	t := scheduler.NewFallibleTask(when, scheduler.Tags{tagKind: kindPrune}, PruneImages(app))
+	return scheduler.Repeat(t, schedule)
+}
+
+// PruneImages returns a task function which applies the retention policy
+// of every camera, removing images it doesn't keep (or only logging them
+// if the config's Retention.DryRun is set).
+func PruneImages(app *Application) func(time.Time) error {
+	return func(now time.Time) error {
+		cfg := app.config()
+		report, err := applyRetention(cfg, app.Clock.Now(), cfg.Retention.DryRun, 0)
+		if err != nil {
+			return err
+		}
+
+		verb := "pruned"
+		if report.DryRun {
+			verb = "would prune"
+		}
+		for _, cam := range report.Cameras {
+			if cam.Error != "" {
+				log.Printf(log.Error, "(mtID=%d camID=%d) pruning images: %s", cam.MountainID, cam.ID, cam.Error)
+			}
+			if cam.Pruned > 0 {
+				log.Printf(log.Debug, "(mtID=%d camID=%d) %s %d images (%d bytes), kept %d",
+					cam.MountainID, cam.ID, verb, cam.Pruned, cam.Bytes, cam.Kept)
+			}
+		}
+		log.Printf(log.Info, "%s %d images (%d bytes) of %d cameras",
+			verb, report.Pruned, report.Bytes, len(report.Cameras))
+		return nil
+	}
+}
+
+// applyRetention applies the retention policy of camID, or every camera if
+// camID is 0, at now. The images the policies don't keep are deleted and
+// their scrapes are marked as pruned, unless dryRun is set. Cameras without
+// a policy (or with one which keeps everything) aren't in the report.
+func applyRetention(cfg *ScrapedConfig, now time.Time, dryRun bool, camID int) (retentionReport, error) {
+	report := retentionReport{Now: now, DryRun: dryRun, Cameras: []retentionCamera{}}
+
+	images, err := cfg.OpenStorage()
+	if err != nil {
+		return report, err
+	}
+	mts, err := db.Mountains()
+	if err != nil {
+		return report, errors.Wrap(err, "reading mountains")
+	}
+	cams, err := db.Cameras()
+	if err != nil {
+		return report, errors.Wrap(err, "reading cameras")
+	}
+	ids := make([]int, 0, len(cams))
+	for id := range cams {
+		if camID == 0 || id == camID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	for _, id := range ids {
+		cam, mt := cams[id], mts[cams[id].MountainID]
+		policy, ok, err := cam.RetentionPolicy()
+		if !ok {
+			policy = cfg.Retention.Policy
+		}
+		if err == nil && policy.IsZero() {
+			continue
+		}
+		result := retentionCamera{ID: id, MountainID: cam.MountainID}
+		if err == nil {
+			err = retainCamera(&result, images, mt, cam, policy, now, dryRun)
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		report.Pruned += result.Pruned
+		report.Bytes += result.Bytes
+		report.Cameras = append(report.Cameras, result)
+	}
+	return report, nil
+}
+
+// retainCamera applies policy to the images of cam, adding the outcome to
+// result. The thumbnails of a pruned image are deleted with it.
+func retainCamera(result *retentionCamera, images storage.Storage, mt model.Mountain, cam model.Camera,
+	policy model.RetentionPolicy, now time.Time, dryRun bool) error {
+
+	scrapes, err := db.RetainedScrapes(cam.ID)
+	if err != nil {
+		return err
+	}
+	tz, err := time.LoadLocation(mt.TzLocation)
+	if err != nil {
+		tz = time.UTC
+	}
+
+	prune := prunable(scrapes, policy, now, tz)
+	result.Kept = len(scrapes) - len(prune)
+	for _, s := range prune {
+		key := storage.Key(mt.Pathname, cam.Pathname, s.Filename)
+		if dryRun {
+			result.Files = append(result.Files, key)
+		} else {
+			if err := images.Delete(key); err != nil {
+				result.Kept++
+				log.Printf(log.Error, "(mtID=%d camID=%d) %s", mt.ID, cam.ID, err)
+				continue
+			}
+			for _, name := range s.ThumbnailNames() {
+				thumb := storage.Key(mt.Pathname, cam.Pathname, model.ThumbnailFilename(s.Filename, name))
+				if err := images.Delete(thumb); err != nil {
+					log.Printf(log.Error, "(mtID=%d camID=%d) %s", mt.ID, cam.ID, err)
+				}
+			}
+			if err := db.SetScrapePruned(s.ID, now); err != nil {
+				// the image is gone, so the scrape is pruned either way
+				log.Printf(log.Error, "(mtID=%d camID=%d) %s", mt.ID, cam.ID, err)
+			}
+		}
+		result.Pruned++
+		result.Bytes += s.Size
+	}
+	return nil
+}
+
+// prunable returns the scrapes (oldest first) whose images aren't kept by
+// policy at now. The periods of the policy's tiers are in the mountain's
+// timezone tz, so that a tier which keeps one image a day keeps the first
+// of each local day.
+func prunable(scrapes []model.Scrape, policy model.RetentionPolicy, now time.Time, tz *time.Location) []model.Scrape {
+	type period struct {
+		tier model.RetentionTier
+		n    int64
+	}
+	seen := make(map[period]bool)
+	maxAge := time.Duration(policy.MaxDays) * 24 * time.Hour
+
+	sort.SliceStable(scrapes, func(i, j int) bool { return scrapes[i].Created.Before(scrapes[j].Created) })
+	var prune, kept []model.Scrape
+	for _, s := range scrapes {
+		age := now.Sub(s.Created)
+		if policy.MaxDays > 0 && age > maxAge {
+			prune = append(prune, s)
+			continue
+		}
+		if tier := policy.Tier(age); tier != nil {
+			_, offset := s.Created.In(tz).Zone()
+			p := period{*tier, (s.Created.Unix() + int64(offset)) / int64(tier.EveryMin*60)}
+			if seen[p] {
+				prune = append(prune, s)
+				continue
+			}
+			seen[p] = true
+		}
+		kept = append(kept, s)
+	}
+
+	// the hard cap removes the oldest of the images which are left
+	if policy.MaxImages > 0 && len(kept) > policy.MaxImages {
+		prune = append(prune, kept[:len(kept)-policy.MaxImages]...)
+		sort.SliceStable(prune, func(i, j int) bool { return prune[i].Created.Before(prune[j].Created) })
+	}
+	return prune
+}
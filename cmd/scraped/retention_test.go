@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quillaja/mtcam/model"
+)
+
+func TestPrunable(t *testing.T) {
+	tz, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2019, 8, 8, 12, 0, 0, 0, tz)
+	day := 24 * time.Hour
+
+	// a scrape every 20 minutes for 400 days
+	var scrapes []model.Scrape
+	for when := now.Add(-400 * day); !when.After(now); when = when.Add(20 * time.Minute) {
+		scrapes = append(scrapes, model.Scrape{ID: len(scrapes) + 1, Created: when})
+	}
+	kept := func(prune []model.Scrape) map[int]bool {
+		kept := make(map[int]bool)
+		for _, s := range scrapes {
+			kept[s.ID] = true
+		}
+		for i, s := range prune {
+			if i > 0 && s.Created.Before(prune[i-1].Created) {
+				t.Errorf("pruned scrapes out of order at %d", i)
+			}
+			delete(kept, s.ID)
+		}
+		return kept
+	}
+	count := func(kept map[int]bool, from, to time.Duration) (n int) {
+		for _, s := range scrapes {
+			if age := now.Sub(s.Created); age >= from && age < to && kept[s.ID] {
+				n++
+			}
+		}
+		return
+	}
+
+	// everything for 30 days, then hourly for a year, then daily
+	policy := model.RetentionPolicy{Tiers: []model.RetentionTier{
+		{AfterDays: 365, EveryMin: 1440},
+		{AfterDays: 30, EveryMin: 60}}}
+	k := kept(prunable(scrapes, policy, now, tz))
+	if n := count(k, 0, 30*day); n != 30*72 {
+		t.Errorf("kept %d of the last 30 days, want %d", n, 30*72)
+	}
+	if n := count(k, 31*day, 41*day); n != 10*24 {
+		t.Errorf("kept %d of 10 days after 30 days, want %d", n, 10*24)
+	}
+	if n := count(k, 366*day, 376*day); n != 10 {
+		t.Errorf("kept %d of 10 days after a year, want 10", n)
+	}
+	// one a day is the first of the local day
+	for _, s := range scrapes {
+		if age := now.Sub(s.Created); age > 366*day && age < 399*day && k[s.ID] {
+			if local := s.Created.In(tz); local.Hour() != 0 || local.Minute() != 0 {
+				t.Errorf("kept %s, want the first of the day", local)
+			}
+			break
+		}
+	}
+
+	// thinning again removes nothing
+	var left []model.Scrape
+	for _, s := range scrapes {
+		if k[s.ID] {
+			left = append(left, s)
+		}
+	}
+	if prune := prunable(left, policy, now, tz); len(prune) != 0 {
+		t.Errorf("pruned %d scrapes again", len(prune))
+	}
+
+	// hard caps
+	k = kept(prunable(scrapes, model.RetentionPolicy{MaxDays: 10}, now, tz))
+	if len(k) != 10*72+1 || count(k, 0, 10*day+time.Minute) != len(k) {
+		t.Errorf("max 10 days kept %d", len(k))
+	}
+	policy.MaxImages = 100
+	k = kept(prunable(scrapes, policy, now, tz))
+	if len(k) != 100 || count(k, 0, 100*20*time.Minute) != 100 {
+		t.Errorf("max 100 images kept %d, not the newest", len(k))
+	}
+
+	// the zero policy keeps everything
+	if prune := prunable(scrapes, model.RetentionPolicy{}, now, tz); len(prune) != 0 {
+		t.Errorf("zero policy pruned %d scrapes", len(prune))
+	}
+}
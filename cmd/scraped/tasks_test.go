@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/quillaja/mtcam/astro"
+	"github.com/quillaja/mtcam/model"
+	"github.com/quillaja/mtcam/scheduler"
+)
+
+func TestStartOfNextDay(t *testing.T) {
+	pacific, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want time.Time
+	}{
+		{"normal", time.Date(2026, 6, 1, 13, 0, 0, 0, pacific), time.Date(2026, 6, 2, 0, 0, 0, 0, pacific)},
+		{"end of month", time.Date(2026, 10, 31, 23, 59, 0, 0, pacific), time.Date(2026, 11, 1, 0, 0, 0, 0, pacific)},
+		{"dst starts", time.Date(2026, 3, 8, 1, 0, 0, 0, pacific), time.Date(2026, 3, 9, 0, 0, 0, 0, pacific)},
+		{"dst ends", time.Date(2026, 11, 1, 1, 30, 0, 0, pacific), time.Date(2026, 11, 2, 0, 0, 0, 0, pacific)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := startOfNextDay(tt.t); !got.Equal(tt.want) {
+				t.Errorf("startOfNextDay(%s) = %s, want %s", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestScheduleCameraDST schedules a whole day of scrapes for a camera on
+// the day daylight saving time starts, using a fake clock.
+func TestScheduleCameraDST(t *testing.T) {
+	pacific, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	midnight := time.Date(2026, 3, 8, 0, 0, 0, 0, pacific)
+	clock := scheduler.NewFakeClock(midnight)
+	app := &Application{
+		Config:    &ScrapedConfig{},
+		Clock:     clock,
+		Scheduler: scheduler.NewScheduler(scheduler.WithClock(clock))}
+
+	mt := model.Mountain{ID: 1, Latitude: 45.373439, Longitude: -121.695962, TzLocation: pacific.String()}
+	always := model.Camera{ID: 1, MountainID: 1, IsActive: true, Interval: 5, Rules: "true"}
+	daylight := model.Camera{ID: 2, MountainID: 1, IsActive: true, Interval: 10, Rules: "{{ betweenRiseSet .Now .Astro 0 }}"}
+	inactive := model.Camera{ID: 3, MountainID: 1, IsActive: false, Interval: 5, Rules: "true"}
+
+	sun, err := astro.GetLocal(mt.Latitude, mt.Longitude, midnight)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cam := range []model.Camera{always, daylight, inactive} {
+		if err := scheduleCamera(midnight, mt, cam, sun, app); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts := map[string]int{}
+	for _, e := range app.Scheduler.List() {
+		counts[e.Tags[tagCamera]]++
+		if !e.When.Before(startOfNextDay(midnight)) {
+			t.Errorf("scrape scheduled for the next day at %s", e.When)
+		}
+	}
+	if counts["1"] != 23*12 {
+		t.Errorf("%d scrapes for a 5 minute camera in a 23 hour day, want %d", counts["1"], 23*12)
+	}
+	if counts["2"] == 0 || counts["2"] >= 23*6 {
+		t.Errorf("%d scrapes for a daylight only camera", counts["2"])
+	}
+	if counts["3"] != 0 {
+		t.Errorf("%d scrapes for an inactive camera", counts["3"])
+	}
+
+	// rescheduling the camera replaces its scrapes instead of adding more
+	if n := removeScrapes(app, tagCamera, always.ID); n != 23*12 {
+		t.Errorf("removed %d scrapes, want %d", n, 23*12)
+	}
+}
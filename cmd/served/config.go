@@ -3,19 +3,24 @@ package main
 import "github.com/quillaja/mtcam/config"
 
 // ServedConfig holds configuration settings for the served program.
+//
+// The config is reloaded when its file changes (checked every ConfigWatchSec
+// seconds, or never if 0) or served receives SIGHUP. Fields tagged
+// `config:"restart"` only change when served is restarted.
 type ServerdConfig struct {
 	config.SuiteConfig `json:"-"`
 
-	SuiteConfigPath string // path to suite config file
+	SuiteConfigPath string `config:"restart"` // path to suite config file
+	ConfigWatchSec  int    `config:"restart"`
 
-	HttpsAddress string // eg 123.1.1.123:8080, :8080, :http etc
-	HttpAddress  string
+	HttpsAddress string `config:"restart"` // eg 123.1.1.123:8080, :8080, :http etc
+	HttpAddress  string `config:"restart"`
 
 	// TLS (https) certificate stuff
-	TLSCertificateFile string
-	TLSKeyFile         string
+	TLSCertificateFile string `config:"restart"`
+	TLSKeyFile         string `config:"restart"`
 
-	Timeout TimeoutConfig
+	Timeout TimeoutConfig `config:"restart"`
 
 	// static root directory
 	StaticRoot string
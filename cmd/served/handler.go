@@ -13,6 +13,7 @@ import (
 	"github.com/quillaja/mtcam/db"
 	"github.com/quillaja/mtcam/log"
 	"github.com/quillaja/mtcam/model"
+	"github.com/quillaja/mtcam/storage"
 )
 
 // content type header
@@ -55,9 +56,15 @@ func CreateHandler(cfg *ServerdConfig) http.Handler {
 	mux.HandleFunc(cfg.Routes.Api+"data/", ApiData(cfg))
 	mux.HandleFunc(cfg.Routes.Api+"mountains/", ApiScrapes(cfg))
 
-	// add handlers for image folder
-	mux.Handle(cfg.Routes.Image, http.StripPrefix(
-		cfg.Routes.Image, http.FileServer(http.Dir(cfg.ImageRoot))))
+	// add handlers for images. the storage was checked when the config
+	// was read.
+	images, err := cfg.OpenStorage()
+	if err != nil {
+		log.Printf(log.Critical, "couldn't open image storage: %s", err)
+	} else {
+		mux.Handle(cfg.Routes.Image, http.StripPrefix(
+			cfg.Routes.Image, storage.FileServer(images)))
+	}
 
 	// add handler for root (static files)
 	// use "StaticRoot" if set, fallback to embedded client
@@ -194,12 +201,19 @@ func ApiScrapes(cfg *ServerdConfig) http.HandlerFunc {
 		}
 
 		// 1) process scrapes by replaces their filename with the complete
-		// path to the image file
+		// path to the image file (if it hasn't been pruned), and adding the
+		// paths of its thumbnails
 		// 2) change 'created' time to be in mountain's timezone
 		tz, _ := time.LoadLocation(mt.TzLocation)
 		for i := range scrapes {
-			if scrapes[i].Result == model.Success {
-				scrapes[i].Filename = path.Join(cfg.Routes.Image, mt.Pathname, cam.Pathname, scrapes[i].Filename)
+			if scrapes[i].Result == model.Success && scrapes[i].Pruned.IsZero() {
+				dir := path.Join(cfg.Routes.Image, mt.Pathname, cam.Pathname)
+				for _, name := range scrapes[i].ThumbnailNames() {
+					scrapes[i].Thumbs = append(scrapes[i].Thumbs, model.ScrapeThumb{
+						Name: name,
+						File: path.Join(dir, model.ThumbnailFilename(scrapes[i].Filename, name))})
+				}
+				scrapes[i].Filename = path.Join(dir, scrapes[i].Filename)
 			} else {
 				scrapes[i].Filename = ""
 			}
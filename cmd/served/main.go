@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -45,18 +46,11 @@ func main() {
 	}
 
 	// read and create config
-	var cfg ServerdConfig
-	err := config.Read(*configPath, &cfg)
+	cfg, err := readConfig(*configPath)
 	if err != nil {
-		log.Printf(log.Error, "could read config %s: %s", *configPath, err)
+		log.Print(log.Error, err)
 		return
 	}
-	err = config.Read(cfg.SuiteConfigPath, &cfg.SuiteConfig)
-	if err != nil {
-		log.Printf(log.Error, "could read suit config %s: %s", cfg.SuiteConfigPath, err)
-		return
-	}
-	// TODO: watch config file(s) and update on the fly.
 
 	// 'connect' to database
 	err = db.Connect(cfg.DatabaseConnection)
@@ -68,13 +62,18 @@ func main() {
 
 	app := NewApplication(&cfg)
 
-	sig := make(chan os.Signal)
-	signal.Notify(sig, os.Kill, os.Interrupt, syscall.SIGTERM)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Kill, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	log.Printf(log.Info, "starting server daemon %s", version.Version)
 	app.run()
+	stopWatching := app.watchConfig(*configPath)
+	defer stopWatching()
 
-	<-sig // wait for SIGKILL, SIGINT, or SIGTERM
+	// wait for SIGKILL, SIGINT, or SIGTERM, reloading the config on SIGHUP
+	for s := <-sig; s == syscall.SIGHUP; s = <-sig {
+		app.reloadConfig(*configPath)
+	}
 
 	log.Printf(log.Info, "shutting down server daemon %s", version.Version)
 	app.shutdown()
@@ -83,9 +82,16 @@ func main() {
 
 // Application is the served application logic.
 type Application struct {
+	// Config is replaced when the config is reloaded, so it's read
+	// with config() once the app is running
 	Config      *ServerdConfig
+	configMutex sync.RWMutex
+
 	HttpsServer *http.Server
 	HttpServer  *http.Server
+
+	// handler of the main server, replaced when the config is reloaded
+	handler swapHandler
 }
 
 // NewApplication configures and returns an instance of Application.
@@ -93,13 +99,8 @@ func NewApplication(cfg *ServerdConfig) *Application {
 	app := Application{
 		Config: cfg}
 
-	// set default addresses
-	if cfg.HttpsAddress == "" {
-		cfg.HttpsAddress = ":https"
-	}
-	if cfg.HttpAddress == "" {
-		cfg.HttpAddress = ":http"
-	}
+	setDefaults(cfg)
+	app.handler.set(CreateHandler(cfg))
 
 	dohttps := cfg.TLSCertificateFile != "" && cfg.TLSKeyFile != ""
 
@@ -111,11 +112,11 @@ func NewApplication(cfg *ServerdConfig) *Application {
 			IdleTimeout:  time.Duration(cfg.Timeout.Idle) * time.Second,
 			ReadTimeout:  time.Duration(cfg.Timeout.Read) * time.Second,
 			WriteTimeout: time.Duration(cfg.Timeout.Write) * time.Second,
-			Handler:      CreateHandler(cfg),
+			Handler:      &app.handler,
 			ErrorLog:     stdlog.New(serverlogwriter{}, "HTTPS ", stdlog.Lshortfile),
 		}
 
-		app.HttpServer = redirectHTTPS(cfg)
+		app.HttpServer = redirectHTTPS(&app)
 
 		log.Printf(log.Info, "listening on %s, redirecting from %s",
 			cfg.HttpsAddress, cfg.HttpAddress)
@@ -129,7 +130,7 @@ func NewApplication(cfg *ServerdConfig) *Application {
 			IdleTimeout:  time.Duration(cfg.Timeout.Idle) * time.Second,
 			ReadTimeout:  time.Duration(cfg.Timeout.Read) * time.Second,
 			WriteTimeout: time.Duration(cfg.Timeout.Write) * time.Second,
-			Handler:      CreateHandler(cfg),
+			Handler:      &app.handler,
 			ErrorLog:     stdlog.New(serverlogwriter{}, "HTTP ", stdlog.Lshortfile),
 		}
 
@@ -144,8 +145,8 @@ func (app *Application) run() {
 	if app.HttpsServer != nil {
 		go func() {
 			err := app.HttpsServer.ListenAndServeTLS(
-				app.Config.TLSCertificateFile,
-				app.Config.TLSKeyFile)
+				app.config().TLSCertificateFile,
+				app.config().TLSKeyFile)
 			if err != nil && err != http.ErrServerClosed {
 				// unexpected error
 				log.Printf(log.Critical, "error with https server: %s", err)
@@ -180,10 +181,24 @@ func (app *Application) shutdown() {
 	}
 }
 
+// setDefaults sets the default of settings missing from cfg.
+func setDefaults(cfg *ServerdConfig) {
+	// set default addresses
+	if cfg.HttpsAddress == "" {
+		cfg.HttpsAddress = ":https"
+	}
+	if cfg.HttpAddress == "" {
+		cfg.HttpAddress = ":http"
+	}
+}
+
 // listens on :http and redirects to the same address, just with https.
-func redirectHTTPS(cfg *ServerdConfig) *http.Server {
+func redirectHTTPS(app *Application) *http.Server {
+	cfg := app.config()
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := app.config() // may have been reloaded
+
 		// deny http request if they're asking for anything but / on one of
 		// the specified hosts.
 		if r.RequestURI != "/" ||
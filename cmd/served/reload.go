@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/quillaja/mtcam/config"
+	"github.com/quillaja/mtcam/log"
+)
+
+// readConfig reads the served config at path and the suite config it
+// refers to.
+func readConfig(path string) (cfg ServerdConfig, err error) {
+	err = config.Read(path, &cfg)
+	if err != nil {
+		return cfg, errors.Wrapf(err, "could read config %s", path)
+	}
+	err = config.Read(cfg.SuiteConfigPath, &cfg.SuiteConfig)
+	if err != nil {
+		return cfg, errors.Wrapf(err, "could read suite config %s", cfg.SuiteConfigPath)
+	}
+	if _, err = cfg.OpenStorage(); err != nil {
+		return cfg, errors.Wrapf(err, "invalid storage in suite config %s", cfg.SuiteConfigPath)
+	}
+	return cfg, nil
+}
+
+// config gets the current config, which must not be modified.
+func (app *Application) config() *ServerdConfig {
+	app.configMutex.RLock()
+	defer app.configMutex.RUnlock()
+	return app.Config
+}
+
+// reloadConfig reads the config at path again, replaces the current config
+// with it, and recreates the handler so new routes, roots and the request
+// log are used by subsequent requests. Settings which can't be changed
+// without restarting served keep their current value and are logged. The
+// current config is kept if the new one can't be read.
+func (app *Application) reloadConfig(path string) {
+	cfg, err := readConfig(path)
+	if err != nil {
+		log.Printf(log.Error, "keeping current config: %s", err)
+		return
+	}
+	setDefaults(&cfg)
+
+	app.configMutex.Lock()
+	restart := config.RestartRequired(app.Config, &cfg)
+	app.Config = &cfg
+	app.configMutex.Unlock()
+	app.handler.set(CreateHandler(&cfg))
+
+	log.Printf(log.Info, "reloaded config %s", path)
+	if len(restart) > 0 {
+		log.Printf(log.Warning, "restart required to change %s", strings.Join(restart, ", "))
+	}
+}
+
+// watchConfig reloads the config when the file at path, or the suite config
+// it refers to, changes. It does nothing if ConfigWatchSec is 0. The
+// returned function stops watching.
+func (app *Application) watchConfig(path string) context.CancelFunc {
+	cfg := app.config()
+	if cfg.ConfigWatchSec <= 0 {
+		return func() {}
+	}
+
+	freq := time.Duration(cfg.ConfigWatchSec) * time.Second
+	changed := func(filename string, err error) {
+		if err != nil {
+			log.Printf(log.Error, "watching config %s: %s", filename, err)
+			return
+		}
+		log.Printf(log.Info, "config %s changed", filename)
+		app.reloadConfig(path)
+	}
+	stopConfig := config.Watch(path, freq, changed)
+	stopSuite := config.Watch(cfg.SuiteConfigPath, freq, changed)
+
+	return func() {
+		stopConfig()
+		stopSuite()
+	}
+}
+
+// swapHandler is an http.Handler which passes requests to another handler
+// that can be replaced while serving.
+type swapHandler struct {
+	m sync.RWMutex
+	h http.Handler
+}
+
+func (s *swapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.m.RLock()
+	h := s.h
+	s.m.RUnlock()
+	h.ServeHTTP(w, r)
+}
+
+// set replaces the handler.
+func (s *swapHandler) set(h http.Handler) {
+	s.m.Lock()
+	s.h = h
+	s.m.Unlock()
+}
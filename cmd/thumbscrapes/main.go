@@ -0,0 +1,115 @@
+// special program created to write the thumbnails configured in the suite
+// config for the images of scrapes made before thumbnails were written (or
+// before the configured thumbnails changed). It can be stopped and run
+// again; only scrapes whose recorded thumbnails differ from the configured
+// ones are read. Thumbnails of sizes no longer configured aren't deleted.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"strings"
+
+	"github.com/disintegration/imaging"
+
+	"github.com/quillaja/mtcam/config"
+	"github.com/quillaja/mtcam/db"
+	"github.com/quillaja/mtcam/pipeline"
+	"github.com/quillaja/mtcam/storage"
+)
+
+func main() {
+	cfgPath := flag.String("cfg", "", "path to suite config (required)")
+	batch := flag.Int("batch", 1000, "number of scrapes read from the db at a time")
+	flag.Parse()
+	if *cfgPath == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var cfg config.SuiteConfig
+	kill(config.Read(*cfgPath, &cfg))
+	if len(cfg.Thumbnails) == 0 {
+		fmt.Println("no thumbnails in suite config")
+		os.Exit(1)
+	}
+	var names []string
+	for _, t := range cfg.Thumbnails {
+		kill(t.Validate())
+		names = append(names, t.Name)
+	}
+	want := strings.Join(names, ",")
+
+	images, err := cfg.OpenStorage()
+	kill(err)
+	kill(db.Connect(cfg.DatabaseConnection))
+	defer db.Close()
+
+	mts, err := db.Mountains()
+	kill(err)
+	cams, err := db.Cameras()
+	kill(err)
+
+	thumbnail := pipeline.Thumbnail{Storage: images, Sizes: cfg.Thumbnails}
+	fmt.Printf("writing %s thumbnails of scrapes in %s\n\n", want, images)
+	var done, partial, skipped, afterID int
+	for {
+		scrapes, err := db.UnthumbnailedScrapes(afterID, *batch, want)
+		kill(err)
+		if len(scrapes) == 0 {
+			break
+		}
+
+		for _, s := range scrapes {
+			afterID = s.ID
+			cam := cams[s.CameraID]
+			mt := mts[cam.MountainID]
+			img, err := open(images, storage.Key(mt.Pathname, cam.Pathname, s.Filename))
+			if err != nil {
+				fmt.Printf("\rskipping scrape(id=%d): %s\n", s.ID, err)
+				skipped++
+				continue
+			}
+
+			// reuse scraped's thumbnail stage so the thumbnails are the same.
+			// not its Run, which deletes the image if a thumbnail fails
+			scrape := pipeline.NewScrape(mt, cam, s.Created)
+			scrape.Image = img
+			scrape.Record = s
+			err = thumbnail.WriteAll(scrape)
+			kill(db.SetScrapeThumbnails(s.ID, scrape.Record.Thumbnails))
+			if err != nil {
+				fmt.Printf("\rscrape(id=%d): %s\n", s.ID, err)
+			}
+			if scrape.Record.Thumbnails != want {
+				fmt.Printf("\rscrape(id=%d) only has thumbnails %q\n", s.ID, scrape.Record.Thumbnails)
+				partial++
+				continue
+			}
+			done++
+		}
+
+		// status display
+		fmt.Printf("\r thumbnailed through rowid %-10d\r", afterID)
+	}
+
+	fmt.Printf("\nfinished thumbnailing. %d scrapes done, %d partial, %d skipped.\n", done, partial, skipped)
+}
+
+// open decodes the image stored as key.
+func open(images storage.Storage, key string) (image.Image, error) {
+	r, err := images.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return imaging.Decode(r)
+}
+
+func kill(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,102 @@
+// special program which checks the images of scrapes against the size and
+// SHA-256 checksum recorded when they were written, to find missing,
+// partially written, or corrupted (bit-rot) images. Scrapes made before
+// checksums were stored in the mtcam database aren't checked.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/quillaja/mtcam/config"
+	"github.com/quillaja/mtcam/db"
+	"github.com/quillaja/mtcam/model"
+	"github.com/quillaja/mtcam/storage"
+)
+
+func main() {
+	cfgPath := flag.String("cfg", "", "path to suite config (required)")
+	batch := flag.Int("batch", 1000, "number of scrapes read from the db at a time")
+	flag.Parse()
+	if *cfgPath == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var cfg config.SuiteConfig
+	kill(config.Read(*cfgPath, &cfg))
+	images, err := cfg.OpenStorage()
+	kill(err)
+	kill(db.Connect(cfg.DatabaseConnection))
+	defer db.Close()
+
+	mts, err := db.Mountains()
+	kill(err)
+	cams, err := db.Cameras()
+	kill(err)
+
+	fmt.Printf("verifying scrapes in %s\n\n", images)
+	var verified, bad, afterID int
+	for {
+		scrapes, err := db.ChecksummedScrapes(afterID, *batch)
+		kill(err)
+		if len(scrapes) == 0 {
+			break
+		}
+
+		for _, s := range scrapes {
+			afterID = s.ID
+			cam := cams[s.CameraID]
+			mt := mts[cam.MountainID]
+			key := storage.Key(mt.Pathname, cam.Pathname, s.Filename)
+
+			if err := verify(images, key, s); err != nil {
+				fmt.Printf("\rscrape(id=%d) %s: %s\n", s.ID, key, err)
+				bad++
+				continue
+			}
+			verified++
+		}
+
+		// status display
+		fmt.Printf("\r verified through rowid %-10d\r", afterID)
+	}
+
+	fmt.Printf("\nfinished verifying. %d scrapes verified, %d bad.\n", verified, bad)
+	if bad > 0 {
+		os.Exit(2)
+	}
+}
+
+// verify returns an error if the image stored as key doesn't have the
+// size and checksum recorded in s.
+func verify(images storage.Storage, key string, s model.Scrape) error {
+	r, err := images.Get(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, r)
+	if err != nil {
+		return err
+	}
+	if size != s.Size {
+		return fmt.Errorf("size %d, want %d", size, s.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != s.SHA256 {
+		return fmt.Errorf("checksum %s, want %s", sum, s.SHA256)
+	}
+	return nil
+}
+
+func kill(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
@@ -54,6 +54,11 @@ func Watch(filename string, freq time.Duration, action func(string, error)) (can
 		if err != nil {
 			action(filename, err)
 		}
+		changed := func(newstat os.FileInfo) bool {
+			return oldstat == nil ||
+				oldstat.ModTime() != newstat.ModTime() ||
+				oldstat.Size() != newstat.Size()
+		}
 
 		for {
 			select {
@@ -67,8 +72,7 @@ func Watch(filename string, freq time.Duration, action func(string, error)) (can
 					continue // go ahead and try again next round
 				}
 
-				if oldstat.ModTime() != newstat.ModTime() ||
-					oldstat.Size() != newstat.Size() {
+				if changed(newstat) {
 					action(filename, nil)
 					oldstat = newstat
 				}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"reflect"
+)
+
+// RestartRequired compares the fields of old and new which are tagged
+// `config:"restart"`, including those in nested structs. old and new must be
+// pointers to the same struct type. Tagged fields which differ are reset to
+// their value in old, since changing them requires restarting the program,
+// and their names (eg "Scheduling.Workers") are returned.
+//
+// Untagged fields of new are left unchanged, so new can then replace old.
+func RestartRequired(old, new interface{}) []string {
+	o, n := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	if o.Type() != n.Type() {
+		panic("config.RestartRequired: " + o.Type().String() + " and " + n.Type().String() + " differ")
+	}
+	return restartRequired(o, n, "")
+}
+
+func restartRequired(old, new reflect.Value, prefix string) (changed []string) {
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		name := prefix + field.Name
+		if field.Anonymous {
+			name = prefix // embedded fields are named as if promoted
+		}
+
+		if field.Tag.Get("config") == "restart" {
+			if !reflect.DeepEqual(old.Field(i).Interface(), new.Field(i).Interface()) {
+				new.Field(i).Set(old.Field(i))
+				changed = append(changed, name)
+			}
+			continue
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			if !field.Anonymous {
+				name += "."
+			}
+			changed = append(changed, restartRequired(old.Field(i), new.Field(i), name)...)
+		}
+	}
+	return changed
+}
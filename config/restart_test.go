@@ -0,0 +1,43 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRestartRequired(t *testing.T) {
+	type inner struct {
+		Live    int
+		Restart int `config:"restart"`
+	}
+	type cfg struct {
+		SuiteConfig
+		Address string `config:"restart"`
+		Agent   string
+		Inner   inner
+	}
+
+	old := cfg{
+		SuiteConfig: SuiteConfig{DatabaseConnection: "a.db", ImageRoot: "img"},
+		Address:     ":80",
+		Agent:       "old",
+		Inner:       inner{Live: 1, Restart: 1}}
+	new := cfg{
+		SuiteConfig: SuiteConfig{DatabaseConnection: "b.db", ImageRoot: "img2"},
+		Address:     ":80",
+		Agent:       "new",
+		Inner:       inner{Live: 2, Restart: 2}}
+
+	changed := RestartRequired(&old, &new)
+	if want := []string{"DatabaseConnection", "Inner.Restart"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed %v, want %v", changed, want)
+	}
+	want := cfg{
+		SuiteConfig: SuiteConfig{DatabaseConnection: "a.db", ImageRoot: "img2"},
+		Address:     ":80",
+		Agent:       "new",
+		Inner:       inner{Live: 2, Restart: 1}}
+	if !reflect.DeepEqual(new, want) {
+		t.Errorf("got %+v, want %+v", new, want)
+	}
+}
@@ -1,10 +1,34 @@
 package config
 
+import (
+	"github.com/quillaja/mtcam/model"
+	"github.com/quillaja/mtcam/storage"
+)
+
 // SuiteConfig contains settings shared among all executables
 // in the cmd folder.
+//
+// Fields tagged `config:"restart"` can't be changed by reloading the config
+// while a program is running. See RestartRequired.
 type SuiteConfig struct {
-	DatabaseDriverName string
-	DatabaseConnection string
+	DatabaseDriverName string `config:"restart"`
+	DatabaseConnection string `config:"restart"`
 
 	ImageRoot string
+
+	// where scraped images are stored. the local driver's root defaults
+	// to ImageRoot.
+	Storage storage.Config
+
+	// sizes of the thumbnails written next to each scraped image
+	Thumbnails []model.Thumbnail
+}
+
+// OpenStorage opens the Storage of scraped images.
+func (c SuiteConfig) OpenStorage() (storage.Storage, error) {
+	cfg := c.Storage
+	if cfg.Root == "" {
+		cfg.Root = c.ImageRoot
+	}
+	return storage.Open(cfg)
 }
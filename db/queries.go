@@ -1,6 +1,7 @@
 package db
 
 import (
+	"database/sql"
 	"fmt"
 	"time"
 
@@ -160,10 +161,12 @@ func Cameras() (cams map[int]model.Camera, err error) {
 	SELECT 
 		rowid, created, modified, name,
 		elevation_ft, latitude, longitude,
-		url,
+		url, extract,
 		file_ext, is_active, interval, delay, rules,
 		comment, pathname,
-		mountain_id 
+		mountain_id, pipeline, request, image, retention,
+		health, health_changed, duplicates, failures,
+		etag, last_modified
 	FROM 
 		camera`
 
@@ -175,6 +178,7 @@ func Cameras() (cams map[int]model.Camera, err error) {
 
 	cams = make(map[int]model.Camera)
 	var cam model.Camera
+	var changed sql.NullTime
 	for rows.Next() {
 		err2 := rows.Scan(
 			&cam.ID,
@@ -185,6 +189,7 @@ func Cameras() (cams map[int]model.Camera, err error) {
 			&cam.Latitude,
 			&cam.Longitude,
 			&cam.Url,
+			&cam.Extract,
 			&cam.FileExtension,
 			&cam.IsActive,
 			&cam.Interval,
@@ -192,10 +197,21 @@ func Cameras() (cams map[int]model.Camera, err error) {
 			&cam.Rules,
 			&cam.Comment,
 			&cam.Pathname,
-			&cam.MountainID)
+			&cam.MountainID,
+			&cam.Pipeline,
+			&cam.Request,
+			&cam.Image,
+			&cam.Retention,
+			&cam.Health,
+			&changed,
+			&cam.Duplicates,
+			&cam.Failures,
+			&cam.ETag,
+			&cam.LastModified)
 		if err2 != nil {
 			// TODO: something with the error
 		}
+		cam.HealthChanged = changed.Time
 		cams[cam.ID] = cam
 	}
 
@@ -207,10 +223,12 @@ func CamerasOnMountain(mID int) (cams map[int]model.Camera, err error) {
 	SELECT 
 		rowid, created, modified, name,
 		elevation_ft, latitude, longitude,
-		url,
+		url, extract,
 		file_ext, is_active, interval, delay, rules,
 		comment, pathname,
-		mountain_id 
+		mountain_id, pipeline, request, image, retention,
+		health, health_changed, duplicates, failures,
+		etag, last_modified
 	FROM 
 		camera
 	WHERE
@@ -224,6 +242,7 @@ func CamerasOnMountain(mID int) (cams map[int]model.Camera, err error) {
 
 	cams = make(map[int]model.Camera)
 	var cam model.Camera
+	var changed sql.NullTime
 	for rows.Next() {
 		err2 := rows.Scan(
 			&cam.ID,
@@ -234,6 +253,7 @@ func CamerasOnMountain(mID int) (cams map[int]model.Camera, err error) {
 			&cam.Latitude,
 			&cam.Longitude,
 			&cam.Url,
+			&cam.Extract,
 			&cam.FileExtension,
 			&cam.IsActive,
 			&cam.Interval,
@@ -241,10 +261,21 @@ func CamerasOnMountain(mID int) (cams map[int]model.Camera, err error) {
 			&cam.Rules,
 			&cam.Comment,
 			&cam.Pathname,
-			&cam.MountainID)
+			&cam.MountainID,
+			&cam.Pipeline,
+			&cam.Request,
+			&cam.Image,
+			&cam.Retention,
+			&cam.Health,
+			&changed,
+			&cam.Duplicates,
+			&cam.Failures,
+			&cam.ETag,
+			&cam.LastModified)
 		if err2 != nil {
 			// TODO: something with the error
 		}
+		cam.HealthChanged = changed.Time
 		cams[cam.ID] = cam
 	}
 
@@ -264,14 +295,18 @@ func Camera(id int) (c model.Camera, err error) {
 	SELECT
 		rowid, created, modified, name,
 		elevation_ft, latitude, longitude,
-		url, file_ext,
+		url, extract, file_ext,
 		is_active, interval, delay, rules,
-		comment, pathname, mountain_id
+		comment, pathname, mountain_id,
+		pipeline, request, image, retention,
+		health, health_changed, duplicates, failures,
+		etag, last_modified
 	FROM camera
 	WHERE
 		rowid=?
 	LIMIT 1`
 
+	var changed sql.NullTime
 	row := db.QueryRow(query, id)
 	err = row.Scan(
 		&c.ID,
@@ -282,6 +317,7 @@ func Camera(id int) (c model.Camera, err error) {
 		&c.Latitude,
 		&c.Longitude,
 		&c.Url,
+		&c.Extract,
 		&c.FileExtension,
 		&c.IsActive,
 		&c.Interval,
@@ -289,10 +325,21 @@ func Camera(id int) (c model.Camera, err error) {
 		&c.Rules,
 		&c.Comment,
 		&c.Pathname,
-		&c.MountainID)
+		&c.MountainID,
+		&c.Pipeline,
+		&c.Request,
+		&c.Image,
+		&c.Retention,
+		&c.Health,
+		&changed,
+		&c.Duplicates,
+		&c.Failures,
+		&c.ETag,
+		&c.LastModified)
 	if err != nil {
 		return c, errors.Wrap(err, "db.Camera(id)")
 	}
+	c.HealthChanged = changed.Time
 
 	return
 }
@@ -301,11 +348,12 @@ func InsertCamera(c *model.Camera) error {
 	const query = `
 	INSERT INTO camera
 		(created, modified, name, elevation_ft, latitude, longitude,
-		url, file_ext,
+		url, extract, file_ext,
 		is_active, interval, delay, rules,
-		comment, pathname, mountain_id)
+		comment, pathname, mountain_id,
+		pipeline, request, image, retention)
 	VALUES
-		(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	// ensure the user doesn't try to assign rowid
 	if c.ID != 0 {
@@ -327,6 +375,7 @@ func InsertCamera(c *model.Camera) error {
 		c.Latitude,
 		c.Longitude,
 		c.Url,
+		c.Extract,
 		c.FileExtension,
 		c.IsActive,
 		c.Interval,
@@ -334,7 +383,11 @@ func InsertCamera(c *model.Camera) error {
 		c.Rules,
 		c.Comment,
 		c.Pathname,
-		c.MountainID)
+		c.MountainID,
+		c.Pipeline,
+		c.Request,
+		c.Image,
+		c.Retention)
 	if err != nil {
 		return errors.Wrapf(err, "while inserting cam (name: %s)", c.Name)
 	}
@@ -358,6 +411,7 @@ func UpdateCamera(c model.Camera) error {
 		latitude = ?,
 		longitude = ?,
 		url = ?,
+		extract = ?,
 		file_ext = ?,
 		is_active = ?,
 		interval = ?,
@@ -365,7 +419,11 @@ func UpdateCamera(c model.Camera) error {
 		rules = ?,
 		comment = ?,
 		pathname = ?,
-		mountain_id = ?
+		mountain_id = ?,
+		pipeline = ?,
+		request = ?,
+		image = ?,
+		retention = ?
 	WHERE
 		rowid=?`
 
@@ -376,6 +434,7 @@ func UpdateCamera(c model.Camera) error {
 		c.Latitude,
 		c.Longitude,
 		c.Url,
+		c.Extract,
 		c.FileExtension,
 		c.IsActive,
 		c.Interval,
@@ -384,6 +443,10 @@ func UpdateCamera(c model.Camera) error {
 		c.Comment,
 		c.Pathname,
 		c.MountainID,
+		c.Pipeline,
+		c.Request,
+		c.Image,
+		c.Retention,
 		c.ID)
 	if err != nil {
 		return errors.Wrapf(err, "updating camera(id=%d)", c.ID)
@@ -399,9 +462,40 @@ func UpdateCamera(c model.Camera) error {
 	return nil
 }
 
+// SetCameraHealth sets the health, health_changed, duplicates, and failures
+// of the camera with c's ID. UpdateCamera doesn't change them, so they
+// aren't lost when a camera is edited.
+func SetCameraHealth(c model.Camera) error {
+	const query = `
+	UPDATE camera
+	SET
+		health = ?,
+		health_changed = ?,
+		duplicates = ?,
+		failures = ?
+	WHERE
+		rowid=?`
+
+	var changed sql.NullTime
+	if !c.HealthChanged.IsZero() {
+		changed = sql.NullTime{Time: floorToSec(c.HealthChanged.In(time.UTC)), Valid: true}
+	}
+	_, err := db.Exec(query, c.Health, changed, c.Duplicates, c.Failures, c.ID)
+	return errors.Wrapf(err, "setting health of camera(id=%d)", c.ID)
+}
+
+// SetCameraValidators sets the etag and last_modified of the camera with
+// c's ID.
+func SetCameraValidators(c model.Camera) error {
+	const query = `UPDATE camera SET etag=?, last_modified=? WHERE rowid=?`
+
+	_, err := db.Exec(query, c.ETag, c.LastModified, c.ID)
+	return errors.Wrapf(err, "setting validators of camera(id=%d)", c.ID)
+}
+
 func Scrapes(camID int, start, end time.Time) (scrapes []model.Scrape, err error) {
 	const query = `
-	SELECT rowid, created, result, detail, filename, camera_id
+	SELECT rowid, created, result, detail, filename, camera_id, hash, size, sha256, pruned, thumbnails
 	FROM scrape
 	WHERE
 		camera_id=?
@@ -418,6 +512,7 @@ func Scrapes(camID int, start, end time.Time) (scrapes []model.Scrape, err error
 
 	scrapes = make([]model.Scrape, 0)
 	var s model.Scrape
+	var pruned sql.NullTime
 	for rows.Next() {
 		err2 := rows.Scan(
 			&s.ID,
@@ -425,7 +520,12 @@ func Scrapes(camID int, start, end time.Time) (scrapes []model.Scrape, err error
 			&s.Result,
 			&s.Detail,
 			&s.Filename,
-			&s.CameraID)
+			&s.CameraID,
+			&s.Hash,
+			&s.Size,
+			&s.SHA256,
+			&pruned,
+			&s.Thumbnails)
 		// TODO: no longer needed because all tables converted to contain tz info
 		// s.Created = time.Date(s.Created.Year(), s.Created.Month(), s.Created.Day(),
 		// 	s.Created.Hour(), s.Created.Minute(), s.Created.Second(), s.Created.Nanosecond(),
@@ -433,6 +533,7 @@ func Scrapes(camID int, start, end time.Time) (scrapes []model.Scrape, err error
 		if err2 != nil {
 			// TODO: something with the error
 		}
+		s.Pruned = pruned.Time
 		scrapes = append(scrapes, s)
 	}
 
@@ -441,7 +542,7 @@ func Scrapes(camID int, start, end time.Time) (scrapes []model.Scrape, err error
 
 func MostRecentScrape(camID int, result string) (s model.Scrape, err error) {
 	const query = `
-	SELECT rowid, created, result, detail, filename, camera_id
+	SELECT rowid, created, result, detail, filename, camera_id, hash, size, sha256, pruned, thumbnails
 	FROM scrape
 	WHERE
 		camera_id=? AND result=?
@@ -449,6 +550,7 @@ func MostRecentScrape(camID int, result string) (s model.Scrape, err error) {
 		created DESC
 	LIMIT 1`
 
+	var pruned sql.NullTime
 	row := db.QueryRow(query, camID, result)
 	err = row.Scan(
 		&s.ID,
@@ -456,10 +558,16 @@ func MostRecentScrape(camID int, result string) (s model.Scrape, err error) {
 		&s.Result,
 		&s.Detail,
 		&s.Filename,
-		&s.CameraID)
+		&s.CameraID,
+		&s.Hash,
+		&s.Size,
+		&s.SHA256,
+		&pruned,
+		&s.Thumbnails)
 	if err != nil {
 		return s, errors.Wrap(err, "db.MostRecentScrape()")
 	}
+	s.Pruned = pruned.Time
 
 	return
 }
@@ -467,9 +575,9 @@ func MostRecentScrape(camID int, result string) (s model.Scrape, err error) {
 func InsertScrape(s *model.Scrape) error {
 	const query = `
 	INSERT INTO scrape
-		(created, result, detail, filename, camera_id)
+		(created, result, detail, filename, camera_id, hash, size, sha256, thumbnails)
 	VALUES
-		(?, ?, ?, ?, ?)`
+		(?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	// ensure the user doesn't try to assign rowid
 	if s.ID != 0 {
@@ -485,7 +593,11 @@ func InsertScrape(s *model.Scrape) error {
 		s.Result,
 		s.Detail,
 		s.Filename,
-		s.CameraID)
+		s.CameraID,
+		s.Hash,
+		s.Size,
+		s.SHA256,
+		s.Thumbnails)
 	if err != nil {
 		return errors.Wrapf(err, "while inserting scrape (cam: %d, time: %s)",
 			s.CameraID, s.Created.Format(time.RFC3339))
@@ -500,8 +612,316 @@ func InsertScrape(s *model.Scrape) error {
 	return nil
 }
 
+// RecentHashes gets the hashes of the n most recent successful scrapes
+// of camID which have a hash, most recent first.
+func RecentHashes(camID int, n int) (hashes []string, err error) {
+	const query = `
+	SELECT hash
+	FROM scrape
+	WHERE
+		camera_id=? AND result=? AND hash!=''
+	ORDER BY
+		created DESC
+	LIMIT ?`
+
+	rows, err := db.Query(query, camID, model.Success, n)
+	if err != nil {
+		return nil, errors.Wrap(err, "db.RecentHashes()")
+	}
+	defer rows.Close()
+
+	hashes = make([]string, 0, n)
+	for rows.Next() {
+		var hash string
+		if err = rows.Scan(&hash); err != nil {
+			return nil, errors.Wrap(err, "db.RecentHashes() scanning row")
+		}
+		hashes = append(hashes, hash)
+	}
+
+	return hashes, rows.Err()
+}
+
+// UnhashedScrapes gets up to limit successful scrapes without a hash,
+// in rowid order starting after the scrape with rowid afterID.
+func UnhashedScrapes(afterID int, limit int) (scrapes []model.Scrape, err error) {
+	const query = `
+	SELECT rowid, created, result, detail, filename, camera_id, hash, size, sha256, pruned, thumbnails
+	FROM scrape
+	WHERE
+		rowid>? AND result=? AND hash='' AND pruned IS NULL
+	ORDER BY
+		rowid ASC
+	LIMIT ?`
+
+	rows, err := db.Query(query, afterID, model.Success, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "db.UnhashedScrapes()")
+	}
+	defer rows.Close()
+
+	scrapes = make([]model.Scrape, 0, limit)
+	for rows.Next() {
+		var s model.Scrape
+		var pruned sql.NullTime
+		err = rows.Scan(
+			&s.ID,
+			&s.Created,
+			&s.Result,
+			&s.Detail,
+			&s.Filename,
+			&s.CameraID,
+			&s.Hash,
+			&s.Size,
+			&s.SHA256,
+			&pruned,
+			&s.Thumbnails)
+		if err != nil {
+			return nil, errors.Wrap(err, "db.UnhashedScrapes() scanning row")
+		}
+		s.Pruned = pruned.Time
+		scrapes = append(scrapes, s)
+	}
+
+	return scrapes, rows.Err()
+}
+
+// ChecksummedScrapes gets up to limit successful scrapes with a checksum,
+// in rowid order starting after the scrape with rowid afterID.
+func ChecksummedScrapes(afterID int, limit int) (scrapes []model.Scrape, err error) {
+	const query = `
+	SELECT rowid, created, result, detail, filename, camera_id, hash, size, sha256, pruned, thumbnails
+	FROM scrape
+	WHERE
+		rowid>? AND result=? AND sha256!='' AND pruned IS NULL
+	ORDER BY
+		rowid ASC
+	LIMIT ?`
+
+	rows, err := db.Query(query, afterID, model.Success, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "db.ChecksummedScrapes()")
+	}
+	defer rows.Close()
+
+	scrapes = make([]model.Scrape, 0, limit)
+	for rows.Next() {
+		var s model.Scrape
+		var pruned sql.NullTime
+		err = rows.Scan(
+			&s.ID,
+			&s.Created,
+			&s.Result,
+			&s.Detail,
+			&s.Filename,
+			&s.CameraID,
+			&s.Hash,
+			&s.Size,
+			&s.SHA256,
+			&pruned,
+			&s.Thumbnails)
+		if err != nil {
+			return nil, errors.Wrap(err, "db.ChecksummedScrapes() scanning row")
+		}
+		s.Pruned = pruned.Time
+		scrapes = append(scrapes, s)
+	}
+
+	return scrapes, rows.Err()
+}
+
+// RetainedScrapes gets the successful scrapes of camID whose images
+// haven't been pruned, oldest first.
+func RetainedScrapes(camID int) (scrapes []model.Scrape, err error) {
+	const query = `
+	SELECT rowid, created, result, detail, filename, camera_id, hash, size, sha256, thumbnails
+	FROM scrape
+	WHERE
+		camera_id=? AND result=? AND filename!='' AND pruned IS NULL
+	ORDER BY
+		created ASC`
+
+	rows, err := db.Query(query, camID, model.Success)
+	if err != nil {
+		return nil, errors.Wrap(err, "db.RetainedScrapes()")
+	}
+	defer rows.Close()
+
+	scrapes = make([]model.Scrape, 0)
+	for rows.Next() {
+		var s model.Scrape
+		err = rows.Scan(
+			&s.ID,
+			&s.Created,
+			&s.Result,
+			&s.Detail,
+			&s.Filename,
+			&s.CameraID,
+			&s.Hash,
+			&s.Size,
+			&s.SHA256,
+			&s.Thumbnails)
+		if err != nil {
+			return nil, errors.Wrap(err, "db.RetainedScrapes() scanning row")
+		}
+		scrapes = append(scrapes, s)
+	}
+
+	return scrapes, rows.Err()
+}
+
+// SetScrapePruned marks the image of the scrape with id as pruned at when.
+func SetScrapePruned(id int, when time.Time) error {
+	const query = `UPDATE scrape SET pruned=? WHERE rowid=?`
+
+	_, err := db.Exec(query, floorToSec(when.In(time.UTC)), id)
+	return errors.Wrapf(err, "setting pruned of scrape(id=%d)", id)
+}
+
+// UnthumbnailedScrapes gets up to limit successful scrapes whose images
+// haven't been pruned and whose recorded thumbnails differ from thumbnails
+// (comma separated names), in rowid order starting after the scrape with
+// rowid afterID.
+func UnthumbnailedScrapes(afterID int, limit int, thumbnails string) (scrapes []model.Scrape, err error) {
+	const query = `
+	SELECT rowid, created, result, detail, filename, camera_id, hash, size, sha256, thumbnails
+	FROM scrape
+	WHERE
+		rowid>? AND result=? AND filename!='' AND pruned IS NULL AND thumbnails!=?
+	ORDER BY
+		rowid ASC
+	LIMIT ?`
+
+	rows, err := db.Query(query, afterID, model.Success, thumbnails, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "db.UnthumbnailedScrapes()")
+	}
+	defer rows.Close()
+
+	scrapes = make([]model.Scrape, 0, limit)
+	for rows.Next() {
+		var s model.Scrape
+		err = rows.Scan(
+			&s.ID,
+			&s.Created,
+			&s.Result,
+			&s.Detail,
+			&s.Filename,
+			&s.CameraID,
+			&s.Hash,
+			&s.Size,
+			&s.SHA256,
+			&s.Thumbnails)
+		if err != nil {
+			return nil, errors.Wrap(err, "db.UnthumbnailedScrapes() scanning row")
+		}
+		scrapes = append(scrapes, s)
+	}
+
+	return scrapes, rows.Err()
+}
+
+// SetScrapeThumbnails sets the thumbnails of the scrape with id.
+func SetScrapeThumbnails(id int, thumbnails string) error {
+	const query = `UPDATE scrape SET thumbnails=? WHERE rowid=?`
+
+	_, err := db.Exec(query, thumbnails, id)
+	return errors.Wrapf(err, "setting thumbnails of scrape(id=%d)", id)
+}
+
+// SetScrapeHash sets the hash of the scrape with id.
+func SetScrapeHash(id int, hash string) error {
+	const query = `UPDATE scrape SET hash=? WHERE rowid=?`
+
+	_, err := db.Exec(query, hash, id)
+	return errors.Wrapf(err, "setting hash of scrape(id=%d)", id)
+}
+
 // floorToSec zeros the nanosecond component of a time.
 func floorToSec(t time.Time) time.Time {
 	return time.Date(t.Year(), t.Month(), t.Day(),
 		t.Hour(), t.Minute(), t.Second(), 0, t.Location())
 }
+
+func PendingTasks() (tasks []model.PendingTask, err error) {
+	const query = `
+	SELECT rowid, kind, args, due, attempt, first_due
+	FROM task
+	ORDER BY
+		due ASC`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, errors.Wrap(err, "db.PendingTasks()")
+	}
+	defer rows.Close()
+
+	tasks = make([]model.PendingTask, 0)
+	for rows.Next() {
+		var t model.PendingTask
+		var firstDue sql.NullTime
+		err = rows.Scan(
+			&t.ID,
+			&t.Kind,
+			&t.Args,
+			&t.Due,
+			&t.Attempt,
+			&firstDue)
+		if err != nil {
+			return nil, errors.Wrap(err, "db.PendingTasks() scanning row")
+		}
+		t.FirstDue = firstDue.Time
+		tasks = append(tasks, t)
+	}
+
+	return tasks, rows.Err()
+}
+
+// ReplacePendingTasks deletes all pending tasks and inserts tasks in
+// their place in a single transaction.
+func ReplacePendingTasks(tasks []model.PendingTask) error {
+	const deleteQuery = `DELETE FROM task`
+	const insertQuery = `
+	INSERT INTO task
+		(kind, args, due, attempt, first_due)
+	VALUES
+		(?, ?, ?, ?, ?)`
+
+	tx, err := db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "beginning pending task transaction")
+	}
+
+	_, err = tx.Exec(deleteQuery)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "deleting pending tasks")
+	}
+
+	stmt, err := tx.Prepare(insertQuery)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "preparing pending task insert")
+	}
+	defer stmt.Close()
+
+	for _, t := range tasks {
+		var firstDue sql.NullTime
+		if !t.FirstDue.IsZero() {
+			firstDue = sql.NullTime{Time: t.FirstDue.In(time.UTC), Valid: true}
+		}
+		_, err = stmt.Exec(
+			t.Kind,
+			t.Args,
+			t.Due.In(time.UTC), // ensure time is in good format
+			t.Attempt,
+			firstDue)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "while inserting pending task (kind: %s, due: %s)",
+				t.Kind, t.Due.Format(time.RFC3339))
+		}
+	}
+
+	return errors.Wrap(tx.Commit(), "committing pending tasks")
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ImageSettings override the image settings of scraped (eg its
+// Image.Width) for a camera. It's stored as JSON in Camera.Image. Zero
+// fields use scraped's settings.
+type ImageSettings struct {
+	// max size of the saved image
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// JPEG quality
+	Quality int `json:"quality,omitempty"`
+	// format (file extension, eg "png") of the saved image. empty is the
+	// camera's FileExtension
+	Format string `json:"format,omitempty"`
+	// rectangle of the downloaded image which is kept
+	Crop *CropRect `json:"crop,omitempty"`
+	// tolerance of the compare stage, and distance of the dedupe stage
+	Tolerance    *float64 `json:"tolerance,omitempty"`
+	HashDistance *int     `json:"hash_distance,omitempty"`
+	// thresholds of the inspect stage. 0 doesn't reject images
+	MinBrightness *float64 `json:"min_brightness,omitempty"`
+	MinContrast   *float64 `json:"min_contrast,omitempty"`
+	// storage keys of the camera's placeholder images (eg "camera
+	// offline"), which the inspect stage rejects
+	Placeholders []string `json:"placeholders,omitempty"`
+}
+
+// CropRect is the rectangle with its top left corner at X, Y. A Width or
+// Height of 0 extends to the right or bottom edge of the image.
+type CropRect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// ImageSettings parses the camera's Image. An empty Image is the zero
+// ImageSettings.
+func (c Camera) ImageSettings() (settings ImageSettings, err error) {
+	if c.Image == "" {
+		return settings, nil
+	}
+	err = json.Unmarshal([]byte(c.Image), &settings)
+	if err != nil {
+		return settings, errors.Wrapf(err, "parsing camera image settings (id=%d, name=%s)", c.ID, c.Name)
+	}
+	return settings, nil
+}
+
+// Thumbnail is a size of the thumbnails written next to each scraped
+// image, as JPEG images named by ThumbnailFilename.
+type Thumbnail struct {
+	// name of the size (eg "small"), which is part of the filename
+	Name string `json:"name"`
+	// max size of the thumbnail, which keeps the image's aspect ratio.
+	// 0 doesn't limit the dimension
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// JPEG quality. 0 is 75
+	Quality int `json:"quality,omitempty"`
+}
+
+// thumbnailName matches valid Thumbnail names.
+var thumbnailName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// Validate returns an error if the thumbnail can't be written.
+func (t Thumbnail) Validate() error {
+	if !thumbnailName.MatchString(t.Name) {
+		return errors.Errorf("thumbnail name %q must be lowercase letters, digits and '-'", t.Name)
+	}
+	if t.Width < 0 || t.Height < 0 || (t.Width == 0 && t.Height == 0) {
+		return errors.Errorf("thumbnail %s needs a positive width or height", t.Name)
+	}
+	return nil
+}
+
+// ThumbnailFilename returns the filename of the thumbnail called name of
+// the image filename, eg "1565257200_small.jpg" for "1565257200.png".
+func ThumbnailFilename(filename, name string) string {
+	return strings.TrimSuffix(filename, path.Ext(filename)) + "_" + name + ".jpg"
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"bytes"
 	"strconv"
+	"strings"
 	"text/template"
 	"time"
 
@@ -38,11 +39,30 @@ type Camera struct {
 	Delay         int       `json:"-"`
 	FileExtension string    `json:"-"`
 	Url           string    `json:"-"`         // template
+	Extract       string    `json:"-"`         // expression extracting the image url from the page at Url. empty if Url is the image
 	IsActive      bool      `json:"is_active"` // master on/off switch
 	Rules         string    `json:"-"`         // template
 	Pathname      string    `json:"pathname"`
+	Pipeline      string    `json:"-"`      // json pipeline definition. empty is the default
+	Request       string    `json:"-"`      // json RequestSpec. empty is a plain GET
+	Image         string    `json:"-"`      // json ImageSettings. empty uses the defaults
+	Retention     string    `json:"-"`      // json RetentionPolicy. empty uses scraped's policy
+	Health        string    `json:"health"` // eg Healthy, set by scraped
+	HealthChanged time.Time `json:"-"`      // time Health last changed. zero if never
+	Duplicates    int       `json:"-"`      // consecutive scrapes of duplicate images
+	Failures      int       `json:"-"`      // consecutive failed scrapes (not duplicates)
+	ETag          string    `json:"-"`      // ETag of the latest fetched image. empty if none
+	LastModified  string    `json:"-"`      // Last-Modified of the latest fetched image. empty if none
 }
 
+// Constants for Camera.Health.
+const (
+	Healthy  = "healthy"  // the camera's latest scrape succeeded
+	Degraded = "degraded" // several recent scrapes failed or were duplicates
+	Frozen   = "frozen"   // the camera has served the same image for a while
+	Down     = "down"     // the camera's scrapes have failed for a while
+)
+
 func (c Camera) ExecuteUrl(data interface{}) (string, error) {
 
 	funcs := template.FuncMap{
@@ -114,17 +134,40 @@ func (c Camera) ExecuteRules(data interface{}) (bool, error) {
 }
 
 type Scrape struct {
-	ID       int       `json:"-"` // primary key
-	CameraID int       `json:"-"` // FK to camera
-	Created  time.Time `json:"time"`
-	Result   string    `json:"result"`
-	Detail   string    `json:"detail"`
-	Filename string    `json:"file"`
+	ID         int           `json:"-"` // primary key
+	CameraID   int           `json:"-"` // FK to camera
+	Created    time.Time     `json:"time"`
+	Result     string        `json:"result"`
+	Detail     string        `json:"detail"`
+	Filename   string        `json:"file"`
+	Hash       string        `json:"-"`                // hex perceptual hash of image. empty if none
+	Size       int64         `json:"-"`                // size in bytes of the image file. 0 if none
+	SHA256     string        `json:"-"`                // hex SHA-256 checksum of the image file. empty if none
+	Pruned     time.Time     `json:"-"`                // time the image file was deleted by retention. zero if not
+	Thumbnails string        `json:"-"`                // comma separated names of the image's thumbnails. empty if none
+	Thumbs     []ScrapeThumb `json:"thumbs,omitempty"` // paths of the thumbnails, in order. set by served
+}
+
+// ScrapeThumb is a thumbnail of a scraped image.
+type ScrapeThumb struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+}
+
+// ThumbnailNames gets the names of the scrape's Thumbnails.
+func (s Scrape) ThumbnailNames() []string {
+	if s.Thumbnails == "" {
+		return nil
+	}
+	return strings.Split(s.Thumbnails, ",")
 }
 
 // Constants for Scrape.Result.
 const (
-	Success = "success"
-	Failure = "failure"
-	Idle    = "idle"
+	Success   = "success"
+	Failure   = "failure"
+	Idle      = "idle"
+	Unchanged = "unchanged" // the camera's image wasn't modified since the previous scrape
+	Rejected  = "rejected"  // the image was unusable, eg black or a placeholder
+	Skipped   = "skipped"   // the scrape wasn't attempted, eg the disk was nearly full
 )
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// RequestSpec customizes the HTTP request which fetches a camera's image.
+// It's stored as JSON in Camera.Request. The zero value is a plain GET.
+type RequestSpec struct {
+	// HTTP method. empty is GET
+	Method string `json:"method,omitempty"`
+	// extra headers (eg Referer), which can override the user agent
+	Headers map[string]string `json:"headers,omitempty"`
+	// "basic" or "bearer" auth using the secret named Secret. a basic auth
+	// secret is "user:password"
+	Auth   string `json:"auth,omitempty"`
+	Secret string `json:"secret,omitempty"`
+	// url of a page requested (with the same headers and auth) before the
+	// image, whose cookies are sent with the image request
+	Preflight string `json:"preflight,omitempty"`
+	// request timeout in seconds. 0 is the default timeout
+	TimeoutSec int `json:"timeout_sec,omitempty"`
+}
+
+// Constants for RequestSpec.Auth.
+const (
+	BasicAuth  = "basic"
+	BearerAuth = "bearer"
+)
+
+// RequestSpec parses the camera's Request. An empty Request is the zero
+// RequestSpec.
+func (c Camera) RequestSpec() (spec RequestSpec, err error) {
+	if c.Request == "" {
+		return spec, nil
+	}
+	err = json.Unmarshal([]byte(c.Request), &spec)
+	if err != nil {
+		return spec, errors.Wrapf(err, "parsing camera request spec (id=%d, name=%s)", c.ID, c.Name)
+	}
+	switch spec.Auth {
+	case "", BasicAuth, BearerAuth:
+	default:
+		return spec, errors.Errorf("unknown auth %q in camera request spec (id=%d, name=%s)", spec.Auth, c.ID, c.Name)
+	}
+	return spec, nil
+}
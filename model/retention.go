@@ -0,0 +1,80 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetentionPolicy decides which images of a camera are kept. Images are
+// thinned by the Tier which applies to their age, and then the oldest are
+// removed to keep at most MaxImages. A camera's policy is stored as JSON in
+// Camera.Retention.
+//
+// For example, keep everything for 30 days, then one image per hour for a
+// year, then one per day forever:
+//
+//	{"tiers": [{"after_days": 30, "every_min": 60}, {"after_days": 365, "every_min": 1440}]}
+type RetentionPolicy struct {
+	Tiers []RetentionTier `json:"tiers,omitempty"`
+	// images older than this many days are removed. 0 is no limit
+	MaxDays int `json:"max_days,omitempty"`
+	// max number of images kept. 0 is no limit
+	MaxImages int `json:"max_images,omitempty"`
+}
+
+// RetentionTier keeps one image (the earliest) in each period of EveryMin
+// minutes, for images older than AfterDays days. The tier with the largest
+// AfterDays not exceeding an image's age applies to it.
+type RetentionTier struct {
+	AfterDays int `json:"after_days"`
+	EveryMin  int `json:"every_min"`
+}
+
+// IsZero reports if the policy keeps every image.
+func (p RetentionPolicy) IsZero() bool {
+	return len(p.Tiers) == 0 && p.MaxDays == 0 && p.MaxImages == 0
+}
+
+// Validate returns an error if the policy can't be applied.
+func (p RetentionPolicy) Validate() error {
+	if p.MaxDays < 0 || p.MaxImages < 0 {
+		return errors.New("retention max_days and max_images can't be negative")
+	}
+	for _, tier := range p.Tiers {
+		if tier.AfterDays < 0 || tier.EveryMin <= 0 {
+			return errors.Errorf("retention tier %+v: after_days can't be negative and every_min must be positive", tier)
+		}
+	}
+	return nil
+}
+
+// Tier returns the tier which applies to an image of age, or nil if none
+// does.
+func (p RetentionPolicy) Tier(age time.Duration) *RetentionTier {
+	var applies *RetentionTier
+	for i, tier := range p.Tiers {
+		if age >= time.Duration(tier.AfterDays)*24*time.Hour &&
+			(applies == nil || tier.AfterDays > applies.AfterDays) {
+			applies = &p.Tiers[i]
+		}
+	}
+	return applies
+}
+
+// RetentionPolicy parses the camera's Retention. ok is false if the camera
+// has no policy of its own.
+func (c Camera) RetentionPolicy() (policy RetentionPolicy, ok bool, err error) {
+	if c.Retention == "" {
+		return policy, false, nil
+	}
+	err = json.Unmarshal([]byte(c.Retention), &policy)
+	if err == nil {
+		err = policy.Validate()
+	}
+	if err != nil {
+		return policy, false, errors.Wrapf(err, "parsing camera retention policy (id=%d, name=%s)", c.ID, c.Name)
+	}
+	return policy, true, nil
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// PendingTask is a scheduled task saved so that it can be restored
+// when the scrape daemon restarts.
+type PendingTask struct {
+	ID   int       // primary key
+	Kind string    // kind of task
+	Args string    // JSON encoded task arguments
+	Due  time.Time // time the task is to be run
+
+	// Attempt is the attempt number of a task being retried after failing,
+	// and FirstDue the time its first attempt was due. Attempt is 0 and
+	// FirstDue is zero if the task isn't a retry.
+	Attempt  int
+	FirstDue time.Time
+}
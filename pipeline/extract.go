@@ -0,0 +1,312 @@
+package pipeline
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	xhtml "golang.org/x/net/html"
+)
+
+// Extractor finds the url of a camera image in an HTML page.
+type Extractor interface {
+	// Extract returns the (possibly relative) image url in page.
+	Extract(page []byte) (string, error)
+}
+
+// ParseExtractor parses an extraction expression, which is one of:
+//
+//	css:<selector>[@attr]   eg css:div#cam > img.latest
+//	path:<path>[/@attr]     eg path:/html/body/div[2]/img/@src
+//	regexp:<regexp>         eg regexp:"(/cam/\d+\.jpg)"
+//
+// Selectors are a subset of CSS: tags (or *), #id, .class, [attr] and
+// [attr=value], combined with descendant (space) and child (>)
+// combinators. Paths are like XPath: steps are tags (or *) with an optional
+// 1-based [index] among siblings of the same tag, separated by / (child)
+// or // (descendant). The url is the value of the attribute of the first
+// matching element, src if not given. A regexp's url is its first
+// submatch, or the whole match if it has none.
+func ParseExtractor(expr string) (Extractor, error) {
+	i := strings.Index(expr, ":")
+	if i < 0 {
+		return nil, errors.Errorf("extract expression %q has no kind (eg css:)", expr)
+	}
+	kind, arg := expr[:i], strings.TrimSpace(expr[i+1:])
+
+	var err error
+	switch kind {
+	case "css":
+		e := elementExtractor{attr: "src"}
+		if at := strings.LastIndex(arg, "@"); at > strings.LastIndex(arg, "]") {
+			arg, e.attr = arg[:at], arg[at+1:]
+		}
+		e.steps, err = parseSelector(arg)
+		return e, errors.Wrapf(err, "parsing selector %q", arg)
+
+	case "path":
+		e := elementExtractor{attr: "src"}
+		if at := strings.LastIndex(arg, "/@"); at >= 0 {
+			arg, e.attr = arg[:at], arg[at+2:]
+		}
+		e.steps, err = parsePath(arg)
+		return e, errors.Wrapf(err, "parsing path %q", arg)
+
+	case "regexp":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing regexp %q", arg)
+		}
+		return regexpExtractor{re}, nil
+	}
+	return nil, errors.Errorf("unknown kind %q of extract expression", kind)
+}
+
+// regexpExtractor extracts the url matched by a regexp.
+type regexpExtractor struct {
+	re *regexp.Regexp
+}
+
+func (e regexpExtractor) Extract(page []byte) (string, error) {
+	m := e.re.FindSubmatch(page)
+	if m == nil {
+		return "", errors.Errorf("no match of %s", e.re)
+	}
+	url := m[0]
+	if len(m) > 1 {
+		url = m[1]
+	}
+	// the match is raw html, so may contain entities such as &amp;
+	return html.UnescapeString(string(url)), nil
+}
+
+// elementExtractor extracts the url in an attribute of the first element
+// matching a selector or path.
+type elementExtractor struct {
+	steps []step
+	attr  string
+}
+
+// step matches an element and its relationship to the element matched by
+// the previous step.
+type step struct {
+	tag     string // empty matches any tag
+	id      string
+	classes []string
+	attrs   []attrMatch
+	index   int  // 1-based index among siblings with the same tag. 0 is any
+	child   bool // the element is a child of the previous step's element (else a descendant)
+}
+
+type attrMatch struct {
+	key, val string
+	hasVal   bool
+}
+
+func (e elementExtractor) Extract(page []byte) (string, error) {
+	doc, err := xhtml.Parse(bytes.NewReader(page))
+	if err != nil {
+		return "", errors.Wrap(err, "parsing page")
+	}
+	n := find(doc, e.steps)
+	if n == nil {
+		return "", errors.New("no matching element")
+	}
+	for _, a := range n.Attr {
+		if a.Key == e.attr {
+			return a.Val, nil
+		}
+	}
+	return "", errors.Errorf("matching element has no %s attribute", e.attr)
+}
+
+// find returns the first node under root, in document order, matching
+// steps.
+func find(root *xhtml.Node, steps []step) *xhtml.Node {
+	for n := root.FirstChild; n != nil; n = n.NextSibling {
+		if matches(n, steps) {
+			return n
+		}
+		if found := find(n, steps); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// matches reports if n matches the last of steps, and its ancestors match
+// the earlier steps.
+func matches(n *xhtml.Node, steps []step) bool {
+	last := steps[len(steps)-1]
+	if !last.match(n) {
+		return false
+	}
+	rest := steps[:len(steps)-1]
+	if len(rest) == 0 {
+		// the first step of a path is a child of the document
+		return !last.child || n.Parent.Type == xhtml.DocumentNode
+	}
+	if last.child {
+		return n.Parent != nil && matches(n.Parent, rest)
+	}
+	for a := n.Parent; a != nil; a = a.Parent {
+		if matches(a, rest) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s step) match(n *xhtml.Node) bool {
+	if n.Type != xhtml.ElementNode || (s.tag != "" && n.Data != s.tag) {
+		return false
+	}
+	if s.id != "" && attr(n, "id") != s.id {
+		return false
+	}
+	classes := strings.Fields(attr(n, "class"))
+	for _, c := range s.classes {
+		if !contains(classes, c) {
+			return false
+		}
+	}
+	for _, a := range s.attrs {
+		v, ok := lookup(n, a.key)
+		if !ok || (a.hasVal && v != a.val) {
+			return false
+		}
+	}
+	if s.index > 0 {
+		i := 1
+		for sib := n.PrevSibling; sib != nil; sib = sib.PrevSibling {
+			if sib.Type == xhtml.ElementNode && sib.Data == n.Data {
+				i++
+			}
+		}
+		return i == s.index
+	}
+	return true
+}
+
+func attr(n *xhtml.Node, key string) string {
+	v, _ := lookup(n, key)
+	return v
+}
+
+func lookup(n *xhtml.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSelector parses a CSS selector into steps.
+func parseSelector(sel string) ([]step, error) {
+	var steps []step
+	child := false
+	for _, tok := range strings.Fields(strings.Replace(sel, ">", " > ", -1)) {
+		if tok == ">" {
+			if len(steps) == 0 || child {
+				return nil, errors.New("misplaced >")
+			}
+			child = true
+			continue
+		}
+		s, err := parseCompound(tok)
+		if err != nil {
+			return nil, err
+		}
+		s.child, child = child, false
+		steps = append(steps, s)
+	}
+	if len(steps) == 0 || child {
+		return nil, errors.New("incomplete selector")
+	}
+	return steps, nil
+}
+
+// parseCompound parses a compound selector such as img#cam.latest[alt].
+func parseCompound(tok string) (s step, err error) {
+	name := func() string {
+		i := strings.IndexAny(tok, "#.[")
+		if i < 0 {
+			i = len(tok)
+		}
+		n := tok[:i]
+		tok = tok[i:]
+		return n
+	}
+
+	s.tag = strings.ToLower(name())
+	if s.tag == "*" {
+		s.tag = ""
+	}
+	for tok != "" {
+		c := tok[0]
+		tok = tok[1:]
+		switch c {
+		case '#':
+			s.id = name()
+		case '.':
+			s.classes = append(s.classes, name())
+		case '[':
+			end := strings.Index(tok, "]")
+			if end < 0 {
+				return s, errors.New("unclosed [")
+			}
+			a := attrMatch{key: tok[:end]}
+			if eq := strings.Index(a.key, "="); eq >= 0 {
+				a.key, a.val, a.hasVal = a.key[:eq], strings.Trim(a.key[eq+1:], `"'`), true
+			}
+			s.attrs = append(s.attrs, a)
+			tok = tok[end+1:]
+		default:
+			return s, errors.Errorf("unexpected %q", c)
+		}
+	}
+	return s, nil
+}
+
+// parsePath parses an XPath-like path into steps.
+func parsePath(path string) ([]step, error) {
+	var steps []step
+	child := strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "//")
+	for _, part := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		if part == "" {
+			// an empty part is between the slashes of //
+			child = false
+			continue
+		}
+		s := step{tag: strings.ToLower(part), child: child}
+		if i := strings.Index(part, "["); i >= 0 && strings.HasSuffix(part, "]") {
+			index, err := strconv.Atoi(part[i+1 : len(part)-1])
+			if err != nil || index < 1 {
+				return nil, errors.Errorf("invalid index in %q", part)
+			}
+			s.tag, s.index = strings.ToLower(part[:i]), index
+		}
+		if s.tag == "*" {
+			s.tag = ""
+		}
+		steps = append(steps, s)
+		child = true
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("empty path")
+	}
+	return steps, nil
+}
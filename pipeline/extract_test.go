@@ -0,0 +1,140 @@
+package pipeline
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quillaja/mtcam/model"
+)
+
+const testPage = `<!DOCTYPE html>
+<html><head><meta property="og:image" content="/og.jpg"></head>
+<body>
+	<div class="header"><img src="/logo.png"></div>
+	<div id="cams">
+		<img class="cam old" src="/cam/1.jpg">
+		<p><img class="cam latest" src="/cam/2.jpg" data-full="/cam/2-full.jpg"></p>
+	</div>
+	<script>var latest = "/cam/3.jpg?a=1&amp;b=2";</script>
+</body></html>`
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"css:img", "/logo.png"},
+		{"css:#cams img", "/cam/1.jpg"},
+		{"css:#cams > img", "/cam/1.jpg"},
+		{"css:div#cams img.cam.latest", "/cam/2.jpg"},
+		{"css:img.latest@data-full", "/cam/2-full.jpg"},
+		{"css:meta[property=og:image]@content", "/og.jpg"},
+		{`css:*[data-full]`, "/cam/2.jpg"},
+		{"path:/html/body/div[2]/img", "/cam/1.jpg"},
+		{"path:/html/body/div[2]/p/img/@data-full", "/cam/2-full.jpg"},
+		{"path://p/img", "/cam/2.jpg"},
+		{"path:body/*[1]/img", "/logo.png"},
+		{`regexp:latest = "([^"]+)"`, "/cam/3.jpg?a=1&b=2"},
+		{`regexp:/cam/\d\.jpg`, "/cam/1.jpg"},
+	}
+	for _, tt := range tests {
+		e, err := ParseExtractor(tt.expr)
+		if err != nil {
+			t.Errorf("%s: %s", tt.expr, err)
+			continue
+		}
+		got, err := e.Extract([]byte(testPage))
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.expr, got, err, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"img", "xpath:/img", "css:", "css:div >", "css:img[src", "path:/html/body/div[x]", "regexp:("} {
+		if _, err := ParseExtractor(expr); err == nil {
+			t.Errorf("%s: parsed invalid expression", expr)
+		}
+	}
+	for _, expr := range []string{"css:video", "path:/body", "css:div@src", "regexp:\\.gif"} {
+		e, _ := ParseExtractor(expr)
+		if url, err := e.Extract([]byte(testPage)); err == nil {
+			t.Errorf("%s: extracted %q from page without match", expr, url)
+		}
+	}
+}
+
+func TestFetchExtract(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page/index.html":
+			fmt.Fprint(w, `<html><body><img id="cam" src="../cam/latest.jpg"></body></html>`)
+		case "/cam/latest.jpg":
+			fmt.Fprint(w, "image")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cam := model.Camera{Extract: "css:img#cam"}
+	s := &Scrape{Camera: cam, URL: server.URL + "/page/index.html"}
+	if err := (Fetch{}).Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if string(s.Data) != "image" || s.URL != server.URL+"/cam/latest.jpg" {
+		t.Errorf("fetched %q from %s", s.Data, s.URL)
+	}
+
+	cam.Extract = "css:img#nope"
+	s = &Scrape{Camera: cam, URL: server.URL + "/page/index.html"}
+	if err := (Fetch{}).Run(s); err == nil || IsRetryable(err) {
+		t.Errorf("page without image url got %v, want permanent error", err)
+	}
+}
+
+func TestFetchExtractOtherHost(t *testing.T) {
+	var cdnRequest *http.Request
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cdnRequest = r
+		fmt.Fprint(w, "image")
+	}))
+	defer cdn.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/landing":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "1"})
+		case "/page":
+			if r.Header.Get(authorization) == "" || r.Header.Get("X-Key") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprintf(w, `<html><body><img id="cam" src="%s/cam.jpg"></body></html>`, cdn.URL)
+		}
+	}))
+	defer server.Close()
+
+	spec := fmt.Sprintf(`{"headers": {"X-Key": "key"}, "auth": "bearer", "secret": "cam",
+		"preflight": "%s/landing"}`, server.URL)
+	cam := model.Camera{Extract: "css:img#cam", Request: spec}
+	s := &Scrape{Camera: cam, URL: server.URL + "/page"}
+	fetch := Fetch{Secrets: map[string]string{"cam": "token"}}
+	if err := fetch.Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if string(s.Data) != "image" || cdnRequest == nil {
+		t.Fatalf("fetched %q from %s", s.Data, s.URL)
+	}
+
+	// the camera's secrets aren't sent to the image's host
+	if auth := cdnRequest.Header.Get(authorization); auth != "" {
+		t.Errorf("image host got Authorization %q", auth)
+	}
+	if key := cdnRequest.Header.Get("X-Key"); key != "" {
+		t.Errorf("image host got X-Key %q", key)
+	}
+	if c, err := cdnRequest.Cookie("session"); err == nil {
+		t.Errorf("image host got cookie %s", c)
+	}
+}
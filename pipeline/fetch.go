@@ -0,0 +1,282 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/quillaja/mtcam/model"
+)
+
+// http headers
+const (
+	useragent             = "User-Agent"
+	contenttype           = "Content-Type"
+	contentlength         = "Content-Length"
+	authorization         = "Authorization"
+	etagHeader            = "ETag"
+	lastModifiedHeader    = "Last-Modified"
+	ifNoneMatchHeader     = "If-None-Match"
+	ifModifiedSinceHeader = "If-Modified-Since"
+)
+
+// Fetch is a Stage which downloads the image at the scrape's URL into
+// its Data.
+//
+// The request is customized by the RequestSpec of the scrape's Camera,
+// which can add headers and auth, make a preflight request for cookies,
+// and set the timeout.
+//
+// If the scrape's Camera has an Extract expression (see ParseExtractor),
+// the scrape's URL is an HTML page, which is downloaded first. The URL is
+// then replaced by the image url extracted from the page.
+//
+// The request is conditional on the ETag and LastModified of the scrape's
+// Camera, if it has them (see Scrape.Validators). If the server responds
+// that the image hasn't been modified, Fetch returns an Unchanged error.
+type Fetch struct {
+	UserAgent string
+	Timeout   time.Duration // 0 is no timeout
+	// Unconditional disables conditional requests, eg for a server which
+	// sends validators but doesn't respect them.
+	Unconditional bool
+	// Secrets used for auth, by name.
+	Secrets map[string]string
+	// MaxSize is the max number of bytes downloaded. Larger images are
+	// rejected with an Invalid error. 0 is unlimited.
+	MaxSize int64
+	// Client, if set, is used for the request instead of a client
+	// with Timeout.
+	Client *http.Client
+}
+
+func (f Fetch) Name() string { return "fetch" }
+
+func (f Fetch) Run(s *Scrape) error {
+	resp, err := f.get(context.Background(), s)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if f.MaxSize > 0 && resp.ContentLength > f.MaxSize {
+		return Invalid(ReasonTooLarge, fmt.Sprintf("%d bytes, more than %d", resp.ContentLength, f.MaxSize), false)
+	}
+
+	body := io.Reader(resp.Body)
+	if f.MaxSize > 0 {
+		body = io.LimitReader(resp.Body, f.MaxSize+1)
+	}
+	s.Header = resp.Header
+	s.Data, err = ioutil.ReadAll(body)
+	if err != nil {
+		return Retryable("trouble downloading image", err)
+	}
+	if f.MaxSize > 0 && int64(len(s.Data)) > f.MaxSize {
+		return Invalid(ReasonTooLarge, fmt.Sprintf("more than %d bytes", f.MaxSize), false)
+	}
+	return nil
+}
+
+// get requests the image at the scrape's URL, returning the response if
+// its status is OK. The request is canceled when ctx is done.
+func (f Fetch) get(ctx context.Context, s *Scrape) (*http.Response, error) {
+	spec, err := s.Camera.RequestSpec()
+	if err != nil {
+		return nil, Fail("invalid request spec", err)
+	}
+
+	client := &http.Client{Timeout: f.Timeout}
+	if f.Client != nil {
+		*client = *f.Client
+	}
+	if spec.TimeoutSec > 0 {
+		client.Timeout = time.Duration(spec.TimeoutSec) * time.Second
+	}
+
+	if spec.Preflight != "" {
+		// cookies set by the preflight response are sent with the
+		// image request
+		client.Jar, _ = cookiejar.New(nil) // never returns an error
+		if err = f.preflight(client, spec); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Camera.Extract != "" {
+		page := s.URL
+		if err = f.extract(client, spec, s); err != nil {
+			return nil, err
+		}
+		// the camera's headers, auth, and cookies are only sent with the
+		// image request if the image is on the page's host, so that they
+		// aren't leaked to eg a CDN
+		if !sameOrigin(page, s.URL) {
+			spec.Headers, spec.Auth = nil, ""
+			client.Jar = nil
+		}
+	}
+
+	request, err := f.newRequest(spec.Method, s.URL, spec)
+	if err != nil {
+		return nil, Fail("invalid request", err)
+	}
+	if !f.Unconditional {
+		if s.Camera.ETag != "" {
+			request.Header.Set(ifNoneMatchHeader, s.Camera.ETag)
+		}
+		if s.Camera.LastModified != "" {
+			request.Header.Set(ifModifiedSinceHeader, s.Camera.LastModified)
+		}
+	}
+	resp, err := client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, Retryable("trouble downloading image", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotModified {
+			return nil, Unchanged("image not modified since previous scrape")
+		}
+		return nil, statusError("trouble downloading image", resp)
+	}
+	return resp, nil
+}
+
+// preflight requests the spec's Preflight page with client, so that its
+// cookies are saved in the client's Jar.
+func (f Fetch) preflight(client *http.Client, spec model.RequestSpec) error {
+	request, err := f.newRequest(http.MethodGet, spec.Preflight, spec)
+	if err != nil {
+		return Fail("invalid preflight request", err)
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return Retryable("trouble with preflight request", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return statusError("trouble with preflight request", resp)
+	}
+	return nil
+}
+
+// maxPageSize is the largest page read by Fetch.extract.
+const maxPageSize = 5 << 20
+
+// extract downloads the page at the scrape's URL with client and replaces
+// the URL with the image url extracted from it by the camera's Extract
+// expression.
+func (f Fetch) extract(client *http.Client, spec model.RequestSpec, s *Scrape) error {
+	extractor, err := ParseExtractor(s.Camera.Extract)
+	if err != nil {
+		return Fail("invalid extract expression", err)
+	}
+
+	request, err := f.newRequest(http.MethodGet, s.URL, spec)
+	if err != nil {
+		return Fail("invalid page request", err)
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return Retryable("trouble downloading page", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError("trouble downloading page", resp)
+	}
+	page, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return Retryable("trouble downloading page", err)
+	}
+
+	found, err := extractor.Extract(page)
+	if err != nil {
+		return Fail("couldn't find image url in page", err)
+	}
+	// the image url is often relative to the page
+	image, err := resp.Request.URL.Parse(strings.TrimSpace(found))
+	if err != nil {
+		return Fail("invalid image url in page", err)
+	}
+	s.URL = image.String()
+	return nil
+}
+
+// newRequest creates a request to url with the method, headers, and auth
+// of spec. An empty method is GET.
+func (f Fetch) newRequest(method, url string, spec model.RequestSpec) (*http.Request, error) {
+	if method == "" {
+		method = http.MethodGet
+	}
+	request, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set(useragent, f.UserAgent)
+	for k, v := range spec.Headers {
+		request.Header.Set(k, v)
+	}
+
+	if spec.Auth == "" {
+		return request, nil
+	}
+	secret, ok := f.Secrets[spec.Secret]
+	if !ok {
+		return nil, errors.Errorf("unknown secret %q", spec.Secret)
+	}
+	switch spec.Auth {
+	case model.BasicAuth:
+		user, password := secret, ""
+		if i := strings.Index(secret, ":"); i >= 0 {
+			user, password = secret[:i], secret[i+1:]
+		}
+		request.SetBasicAuth(user, password)
+	case model.BearerAuth:
+		request.Header.Set(authorization, "Bearer "+secret)
+	}
+	return request, nil
+}
+
+// sameOrigin reports if urls a and b have the same scheme and host
+// (including the port).
+func sameOrigin(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// statusError creates the error returned when resp has an unsuccessful
+// status code, which is retryable if the status is.
+func statusError(detail string, resp *http.Response) error {
+	err := errors.Errorf("status code %s", resp.Status)
+	if retryableStatus(resp.StatusCode) {
+		return Retryable(detail, err)
+	}
+	return Fail(detail, err)
+}
+
+// retryableStatus reports if a request which failed with the HTTP status
+// code might succeed if retried.
+func retryableStatus(code int) bool {
+	return code >= 500 ||
+		code == http.StatusRequestTimeout ||
+		code == http.StatusTooManyRequests
+}
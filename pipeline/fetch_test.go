@@ -0,0 +1,134 @@
+package pipeline
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quillaja/mtcam/model"
+)
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var status int
+		fmt.Sscan(r.URL.Path[1:], &status)
+		if r.Header.Get(useragent) != "mtcam" {
+			status = http.StatusForbidden
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, "image")
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		status int
+		ok     bool
+		retry  bool
+	}{
+		{http.StatusOK, true, false},
+		{http.StatusNotFound, false, false},
+		{http.StatusServiceUnavailable, false, true},
+		{http.StatusTooManyRequests, false, true},
+	}
+	for _, tt := range tests {
+		s := &Scrape{URL: fmt.Sprintf("%s/%d", server.URL, tt.status)}
+		err := Fetch{UserAgent: "mtcam"}.Run(s)
+		if (err == nil) != tt.ok || IsRetryable(err) != tt.retry {
+			t.Errorf("status %d: got error %v, want ok %t retry %t", tt.status, err, tt.ok, tt.retry)
+		}
+		if tt.ok && string(s.Data) != "image" {
+			t.Errorf("status %d: fetched %q", tt.status, s.Data)
+		}
+	}
+
+	// network errors can be retried
+	err := Fetch{}.Run(&Scrape{URL: "http://127.0.0.1:1/"})
+	if err == nil || !IsRetryable(err) {
+		t.Errorf("unreachable host: got %v, want retryable error", err)
+	}
+}
+
+func TestFetchConditional(t *testing.T) {
+	const etag = `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(ifNoneMatchHeader) == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set(etagHeader, etag)
+		w.Header().Set(lastModifiedHeader, "Wed, 21 Oct 2026 07:28:00 GMT")
+		fmt.Fprint(w, "image")
+	}))
+	defer server.Close()
+
+	// first fetch gets the image and its validators
+	s := &Scrape{URL: server.URL}
+	if err := (Fetch{}).Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if tag, modified := s.Validators(); tag != etag || modified == "" {
+		t.Fatalf("validators %q %q", tag, modified)
+	}
+
+	// next fetch is conditional
+	s.Camera.ETag, s.Camera.LastModified = s.Validators()
+	p := &Pipeline{Stages: []Stage{Fetch{}}}
+	next := &Scrape{Camera: s.Camera, URL: server.URL}
+	err := p.Run(next)
+	if !IsUnchanged(err) || !IsDuplicate(err) || IsRetryable(err) {
+		t.Errorf("conditional fetch got %v, want unchanged error", err)
+	}
+	if next.Record.Result != model.Unchanged || next.Data != nil {
+		t.Errorf("conditional fetch recorded %q with %d bytes", next.Record.Result, len(next.Data))
+	}
+
+	// unless disabled
+	next = &Scrape{Camera: s.Camera, URL: server.URL}
+	if err := (Fetch{Unconditional: true}).Run(next); err != nil || string(next.Data) != "image" {
+		t.Errorf("unconditional fetch got %q, %v", next.Data, err)
+	}
+}
+
+func TestFetchRequestSpec(t *testing.T) {
+	const cookie = "session"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/landing" {
+			http.SetCookie(w, &http.Cookie{Name: cookie, Value: "1"})
+			return
+		}
+		user, password, _ := r.BasicAuth()
+		if _, err := r.Cookie(cookie); err != nil || user != "cam" || password != "pa:ss" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, "%s %s %s", r.Method, r.Header.Get("Referer"), r.Header.Get(useragent))
+	}))
+	defer server.Close()
+
+	fetch := Fetch{UserAgent: "mtcam", Secrets: map[string]string{"cam": "cam:pa:ss"}}
+	spec := fmt.Sprintf(`{"method": "POST",
+		"headers": {"Referer": "http://example.com", "User-Agent": "browser"},
+		"auth": "basic", "secret": "cam",
+		"preflight": "%s/landing", "timeout_sec": 5}`, server.URL)
+	s := &Scrape{Camera: model.Camera{Request: spec}, URL: server.URL + "/image"}
+	if err := fetch.Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if want := "POST http://example.com browser"; string(s.Data) != want {
+		t.Errorf("fetched %q, want %q", s.Data, want)
+	}
+
+	// the preflight cookie is required
+	s.Camera.Request = `{"auth": "basic", "secret": "cam"}`
+	if err := fetch.Run(s); err == nil || IsRetryable(err) {
+		t.Errorf("fetch without preflight got %v, want permanent error", err)
+	}
+
+	for _, spec := range []string{`{"auth": "basic", "secret": "nope"}`, `{"auth": "digest"}`, `{`} {
+		s.Camera.Request = spec
+		if err := fetch.Run(s); err == nil || IsRetryable(err) {
+			t.Errorf("spec %s: got %v, want permanent error", spec, err)
+		}
+	}
+}
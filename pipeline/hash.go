@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+
+	"github.com/quillaja/mtcam/log"
+)
+
+// DHash computes the 64 bit difference hash of img. Each bit is set if a
+// pixel of a 9x8 grayscale thumbnail of img is brighter than the pixel to
+// its right, so similar images have hashes which differ in few bits (see
+// Distance) regardless of their size or compression.
+func DHash(img image.Image) uint64 {
+	thumb := imaging.Resize(imaging.Grayscale(img), 9, 8, imaging.Box)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			// grayscale, so any channel is the luminance
+			if thumb.Pix[thumb.PixOffset(x, y)] > thumb.Pix[thumb.PixOffset(x+1, y)] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// FormatHash formats hash as the 16 hex digits stored in a scrape record.
+func FormatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// ParseHash parses a hash formatted by FormatHash.
+func ParseHash(s string) (uint64, error) {
+	hash, err := strconv.ParseUint(s, 16, 64)
+	return hash, errors.Wrapf(err, "parsing hash %q", s)
+}
+
+// Distance is the number of bits which differ in hashes a and b.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Hash is a Stage which sets the Hash of the scrape's Record to the
+// DHash of its Image.
+type Hash struct{}
+
+func (Hash) Name() string { return "hash" }
+
+func (Hash) Run(s *Scrape) error {
+	s.Record.Hash = FormatHash(DHash(s.Image))
+	return nil
+}
+
+// Dedupe is a Stage which fails if the hash of the scrape's Image is
+// within MaxDistance of the hash of one of the camera's recent images. The
+// hash is computed (like the Hash stage) if the scrape doesn't have one.
+type Dedupe struct {
+	// Recent gets the hashes of the camera's most recent successful scrapes.
+	// If it returns an error, the image isn't considered a duplicate.
+	Recent func(s *Scrape) ([]string, error)
+	// MaxDistance is the number of bits which can differ between the
+	// hashes of duplicate images.
+	MaxDistance int
+}
+
+func (Dedupe) Name() string { return "dedupe" }
+
+func (d Dedupe) Run(s *Scrape) error {
+	if s.Record.Hash == "" {
+		Hash{}.Run(s)
+	}
+	hash, _ := ParseHash(s.Record.Hash)
+
+	recent, err := d.Recent(s)
+	if err != nil {
+		log.Printf(log.Error, "%s couldn't get recent hashes: %s", s, err)
+		return nil
+	}
+	for _, r := range recent {
+		prev, err := ParseHash(r)
+		if err != nil {
+			continue
+		}
+		if dist := Distance(hash, prev); dist <= d.MaxDistance {
+			return Duplicate(fmt.Sprintf("image identical to recently scraped image (hash distance %d)", dist))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+
+	"github.com/quillaja/mtcam/model"
+)
+
+func TestDHash(t *testing.T) {
+	img := testImage(200, 100)
+	hash := DHash(img)
+
+	// resizing and compressing the image barely changes its hash
+	buf := new(bytes.Buffer)
+	if err := imaging.Encode(buf, imaging.Resize(img, 120, 60, imaging.Lanczos), imaging.JPEG, imaging.JPEGQuality(60)); err != nil {
+		t.Fatal(err)
+	}
+	small, err := imaging.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := Distance(hash, DHash(small)); d > 4 {
+		t.Errorf("resized image hash distance %d, want <= 4", d)
+	}
+
+	if d := Distance(hash, DHash(imaging.FlipH(img))); d < 32 {
+		t.Errorf("flipped image hash distance %d, want >= 32", d)
+	}
+
+	parsed, err := ParseHash(FormatHash(hash))
+	if err != nil || parsed != hash {
+		t.Errorf("ParseHash(FormatHash(%x)) = %x, %v", hash, parsed, err)
+	}
+	if _, err := ParseHash("not a hash"); err == nil {
+		t.Error("parsed invalid hash")
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	img := testImage(64, 48)
+	prev := FormatHash(DHash(img) ^ 0x3) // 2 bits different
+
+	var recent []string
+	var recentErr error
+	dedupe := Dedupe{
+		Recent:      func(*Scrape) ([]string, error) { return recent, recentErr },
+		MaxDistance: 2}
+
+	tests := []struct {
+		name    string
+		recent  []string
+		err     error
+		max     int
+		wantErr bool
+	}{
+		{"no recent", nil, nil, 2, false},
+		{"duplicate", []string{FormatHash(0), prev}, nil, 2, true},
+		{"beyond max distance", []string{prev}, nil, 1, false},
+		{"invalid recent hash", []string{"bad"}, nil, 2, false},
+		{"recent error", nil, errors.New("db error"), 2, false},
+	}
+	for _, tt := range tests {
+		recent, recentErr, dedupe.MaxDistance = tt.recent, tt.err, tt.max
+		s := NewScrape(model.Mountain{}, model.Camera{}, time.Unix(1565257200, 0))
+		s.Image = img
+		err := dedupe.Run(s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got %v, want error %t", tt.name, err, tt.wantErr)
+		}
+		if err != nil && (IsRetryable(err) || !IsDuplicate(err)) {
+			t.Errorf("%s: got %v, want non-retryable duplicate error", tt.name, err)
+		}
+		if s.Record.Hash != FormatHash(DHash(img)) {
+			t.Errorf("%s: record hash %q not set", tt.name, s.Record.Hash)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package pipeline
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+
+	"github.com/quillaja/mtcam/model"
+	"github.com/quillaja/mtcam/storage"
+)
+
+// testImage creates a w x h image with a gradient so that differences
+// are visible after JPEG compression.
+func testImage(w, h int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), 128, 255})
+		}
+	}
+	return img
+}
+
+// brokenStorage is a Storage which can't store blobs, eg because its
+// server is down.
+type brokenStorage struct{ storage.Local }
+
+func (brokenStorage) Put(key string, r io.Reader) error {
+	return errors.New("503 Service Unavailable")
+}
+
+func TestImageStages(t *testing.T) {
+	buf := new(bytes.Buffer)
+	if err := imaging.Encode(buf, testImage(200, 100), imaging.JPEG); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Scrape{Data: buf.Bytes()}
+	if err := (Decode{}).Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if size := s.Image.Bounds().Size(); size != image.Pt(200, 100) {
+		t.Fatalf("decoded %s image", size)
+	}
+	if err := (Decode{}).Run(&Scrape{Data: []byte("not an image")}); !IsRetryable(err) {
+		t.Errorf("decoding garbage got %v, want retryable error", err)
+	}
+
+	// only shrinks, keeping the aspect ratio
+	Resize{Width: 400, Height: 0}.Run(s)
+	if size := s.Image.Bounds().Size(); size != image.Pt(200, 100) {
+		t.Errorf("resized to %s, want unchanged", size)
+	}
+	Resize{Width: 100, Height: 0}.Run(s)
+	if size := s.Image.Bounds().Size(); size != image.Pt(100, 50) {
+		t.Errorf("resized to %s, want 100x50", size)
+	}
+
+	if err := (Crop{X: 10, Y: 5, Width: 50}).Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if size := s.Image.Bounds().Size(); size != image.Pt(50, 45) {
+		t.Errorf("cropped to %s, want 50x45", size)
+	}
+	if err := (Crop{X: 40, Width: 20}).Run(s); err == nil || IsRetryable(err) {
+		t.Errorf("crop outside image got %v, want permanent error", err)
+	}
+}
+
+func TestCompareAndWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mt := model.Mountain{ID: 1, Pathname: "hood"}
+	cam := model.Camera{ID: 2, Pathname: "palmer", FileExtension: "JPG"}
+	when := time.Unix(1565257200, 0)
+	write := Write{Storage: storage.Local{Root: dir}, Quality: 80}
+
+	s := NewScrape(mt, cam, when)
+	s.Image = testImage(64, 48)
+	if err := write.Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Record.Filename != "1565257200.jpg" {
+		t.Errorf("wrote %s", s.Record.Filename)
+	}
+	// storage errors may be transient, unlike an unsupported format
+	failed := NewScrape(mt, cam, when)
+	failed.Image = s.Image
+	if err := (Write{Storage: brokenStorage{}}).Run(failed); !IsRetryable(err) {
+		t.Errorf("write to broken storage got %v, want retryable error", err)
+	}
+	if err := (Write{Storage: write.Storage, Format: "webp"}).Run(failed); err == nil || IsRetryable(err) {
+		t.Errorf("write as webp got %v, want permanent error", err)
+	}
+
+	path := filepath.Join(dir, "hood", "palmer", s.Record.Filename)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if s.Record.Size != int64(len(data)) || s.Record.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("recorded size %d sha256 %s, file is %d bytes", s.Record.Size, s.Record.SHA256, len(data))
+	}
+
+	previous := func(*Scrape) (image.Image, error) { return imaging.Open(path) }
+	compare := Compare{Previous: previous, Tolerance: 0.02, Quality: 80}
+
+	same := NewScrape(mt, cam, when.Add(time.Minute))
+	same.Image = testImage(64, 48)
+	if err := compare.Run(same); !IsDuplicate(err) {
+		t.Errorf("identical image got %v, want duplicate error", err)
+	}
+
+	different := NewScrape(mt, cam, when.Add(time.Minute))
+	different.Image = imaging.FlipH(testImage(64, 48))
+	if err := compare.Run(different); err != nil {
+		t.Errorf("different image rejected: %s", err)
+	}
+
+	// the image can't be compared if there's no previous image
+	compare.Previous = func(*Scrape) (image.Image, error) { return nil, os.ErrNotExist }
+	if err := compare.Run(same); err != nil {
+		t.Errorf("image without previous image rejected: %s", err)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mt := model.Mountain{ID: 1, Pathname: "hood"}
+	cam := model.Camera{ID: 2, Pathname: "palmer", FileExtension: "png"}
+	thumbs := Thumbnail{
+		Storage: storage.Local{Root: dir},
+		Sizes: []model.Thumbnail{
+			{Name: "small", Width: 32},
+			{Name: "tall", Height: 100}, // larger than the image
+		}}
+
+	if err := thumbs.Run(NewScrape(mt, cam, time.Unix(1565257200, 0))); err == nil {
+		t.Error("thumbnail without written image got no error")
+	}
+
+	s := NewScrape(mt, cam, time.Unix(1565257200, 0))
+	s.Image = testImage(64, 48)
+	s.Record.Filename = "1565257200.png"
+	if err := thumbs.Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Record.Thumbnails != "small,tall" {
+		t.Errorf("recorded thumbnails %q", s.Record.Thumbnails)
+	}
+	for name, want := range map[string]image.Point{"small": image.Pt(32, 24), "tall": image.Pt(64, 48)} {
+		img, err := imaging.Open(filepath.Join(dir, "hood", "palmer", "1565257200_"+name+".jpg"))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if size := img.Bounds().Size(); size != want {
+			t.Errorf("thumbnail %s is %s, want %s", name, size, want)
+		}
+	}
+
+	// the written image is deleted if its thumbnails can't be stored since
+	// the retried scrape will have a different filename
+	failed := NewScrape(mt, cam, time.Unix(1565257260, 0))
+	failed.Image = testImage(64, 48)
+	failed.Record.Filename = "1565257260.png"
+	path := filepath.Join(dir, "hood", "palmer", failed.Record.Filename)
+	if err := ioutil.WriteFile(path, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	thumbs.Storage = brokenStorage{storage.Local{Root: dir}}
+	if err := thumbs.Run(failed); !IsRetryable(err) {
+		t.Errorf("thumbnail to broken storage got %v, want retryable error", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("image of failed scrape wasn't deleted: %v", err)
+	}
+	if failed.Record.Filename != "" {
+		t.Errorf("failed scrape still records filename %q", failed.Record.Filename)
+	}
+}
@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/pkg/errors"
+
+	"github.com/quillaja/mtcam/log"
+	"github.com/quillaja/mtcam/storage"
+)
+
+// Inspect is a Stage which rejects unusable images (see Reject), such as
+// a black frame at night, a solid gray "camera offline" card, or a vendor's
+// placeholder logo. A threshold of 0 disables its check.
+type Inspect struct {
+	// MinBrightness is the minimum mean luminance (0-255) of the image.
+	MinBrightness float64
+	// MinContrast is the minimum standard deviation of the luminance
+	// (0-255) of the image.
+	MinContrast float64
+	// Placeholders are the keys of placeholder images in Storage. Images
+	// whose DHash is within PlaceholderDistance of a placeholder's are
+	// rejected.
+	Placeholders        []string
+	PlaceholderDistance int
+	Storage             storage.Storage
+}
+
+func (Inspect) Name() string { return "inspect" }
+
+func (in Inspect) Run(s *Scrape) error {
+	mean, stddev := Luminance(s.Image)
+	if mean < in.MinBrightness {
+		return Reject(ReasonDark, fmt.Sprintf("brightness %.1f less than %.1f", mean, in.MinBrightness))
+	}
+	if stddev < in.MinContrast {
+		return Reject(ReasonBlank, fmt.Sprintf("contrast %.1f less than %.1f", stddev, in.MinContrast))
+	}
+
+	if len(in.Placeholders) == 0 {
+		return nil
+	}
+	hash := DHash(s.Image)
+	for _, key := range in.Placeholders {
+		placeholder, err := placeholders.hash(in.Storage, key)
+		if err != nil {
+			log.Printf(log.Error, "%s couldn't read placeholder: %s", s, err)
+			continue
+		}
+		if dist := Distance(hash, placeholder); dist <= in.PlaceholderDistance {
+			return Reject(ReasonPlaceholder, fmt.Sprintf("matches %s (hash distance %d)", path.Base(key), dist))
+		}
+	}
+	return nil
+}
+
+// Luminance returns the mean and standard deviation of the luminance
+// (0-255) of the pixels of a thumbnail of img.
+func Luminance(img image.Image) (mean, stddev float64) {
+	thumb := imaging.Grayscale(imaging.Fit(img, 128, 128, imaging.Box))
+
+	var sum, sumsq float64
+	n := 0
+	for y := 0; y < thumb.Bounds().Dy(); y++ {
+		for x := 0; x < thumb.Bounds().Dx(); x++ {
+			// grayscale, so any channel is the luminance
+			l := float64(thumb.Pix[thumb.PixOffset(x, y)])
+			sum += l
+			sumsq += l * l
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	mean = sum / float64(n)
+	return mean, math.Sqrt(math.Max(0, sumsq/float64(n)-mean*mean))
+}
+
+// placeholders caches the hashes of placeholder images.
+var placeholders = placeholderCache{hashes: make(map[string]placeholderHash)}
+
+type placeholderCache struct {
+	sync.Mutex
+	hashes map[string]placeholderHash
+}
+
+type placeholderHash struct {
+	modified time.Time
+	hash     uint64
+}
+
+// hash returns the DHash of the image stored as key in st, which is only
+// read again if it's modified.
+func (c *placeholderCache) hash(st storage.Storage, key string) (uint64, error) {
+	info, err := st.Stat(key)
+	if err != nil {
+		return 0, errors.Wrap(err, "reading placeholder")
+	}
+
+	c.Lock()
+	cached, ok := c.hashes[key]
+	c.Unlock()
+	if ok && cached.modified.Equal(info.Modified) {
+		return cached.hash, nil
+	}
+
+	img, err := decodeBlob(st, key)
+	if err != nil {
+		return 0, errors.Wrap(err, "reading placeholder")
+	}
+	hash := DHash(img)
+	c.Lock()
+	c.hashes[key] = placeholderHash{info.Modified, hash}
+	c.Unlock()
+	return hash, nil
+}
+
+// decodeBlob decodes the image stored as key in st.
+func decodeBlob(st storage.Storage, key string) (image.Image, error) {
+	r, err := st.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return imaging.Decode(r)
+}
@@ -0,0 +1,73 @@
+package pipeline
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/disintegration/imaging"
+
+	"github.com/quillaja/mtcam/storage"
+)
+
+func TestInspect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a placeholder "logo"
+	logo := imaging.New(80, 60, color.White)
+	logo = imaging.Overlay(logo, imaging.New(40, 20, color.Black), image.Pt(20, 20), 1)
+	if err := imaging.Save(logo, filepath.Join(dir, "logo.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	inspect := Inspect{
+		MinBrightness:       20,
+		MinContrast:         5,
+		Placeholders:        []string{"missing.png", "logo.png"},
+		PlaceholderDistance: 4,
+		Storage:             storage.Local{Root: dir}}
+	tests := []struct {
+		name   string
+		img    image.Image
+		reason string
+	}{
+		{"scene", testImage(200, 100), ""},
+		{"black", imaging.New(200, 100, color.Black), ReasonDark},
+		{"gray card", imaging.New(200, 100, color.Gray{128}), ReasonBlank},
+		{"placeholder", imaging.Resize(logo, 160, 120, imaging.Lanczos), ReasonPlaceholder},
+	}
+	for _, tt := range tests {
+		s := &Scrape{Image: tt.img}
+		err := inspect.Run(s)
+		if Reason(err) != tt.reason || (tt.reason == "") != (err == nil) {
+			t.Errorf("%s: got %v, want reason %q", tt.name, err, tt.reason)
+		}
+		if err != nil && (!IsRejected(err) || IsRetryable(err)) {
+			t.Errorf("%s: got %v, want rejected", tt.name, err)
+		}
+	}
+
+	// no thresholds accepts anything
+	if err := (Inspect{}).Run(&Scrape{Image: imaging.New(10, 10, color.Black)}); err != nil {
+		t.Errorf("no thresholds got %v", err)
+	}
+}
+
+func TestLuminance(t *testing.T) {
+	mean, stddev := Luminance(imaging.New(10, 10, color.Gray{100}))
+	if mean != 100 || stddev != 0 {
+		t.Errorf("gray luminance %f, %f", mean, stddev)
+	}
+	half := imaging.Overlay(imaging.New(10, 10, color.White), imaging.New(5, 10, color.Black), image.Pt(0, 0), 1)
+	mean, stddev = Luminance(half)
+	if mean < 127 || mean > 128 || stddev < 127 || stddev > 128 {
+		t.Errorf("half black, half white luminance %f, %f", mean, stddev)
+	}
+}
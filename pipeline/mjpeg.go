@@ -0,0 +1,101 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxFrameSize is the largest frame read by MJPEG.
+const maxFrameSize = 20 << 20
+
+// MJPEG is a Stage which grabs a frame of the MJPEG
+// (multipart/x-mixed-replace) stream at the scrape's URL into its Data.
+// The stream is requested like Fetch requests an image, except that the
+// request is never conditional.
+type MJPEG struct {
+	Fetch
+	// Frame is the 1-based number of the frame grabbed. Grabbing a later
+	// frame skips stale frames buffered by the camera. 0 is 1.
+	Frame int
+	// Deadline is the max time to connect to the stream and read Frame
+	// frames, within the Fetch's Timeout. 0 is only the Timeout.
+	Deadline time.Duration
+}
+
+func (MJPEG) Name() string { return "mjpeg" }
+
+func (m MJPEG) Run(s *Scrape) error {
+	ctx := context.Background()
+	if m.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.Deadline)
+		defer cancel()
+	}
+
+	m.Unconditional = true
+	resp, err := m.get(ctx, s)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	mediatype, _, err := mime.ParseMediaType(resp.Header.Get(contenttype))
+	if err != nil || !strings.HasPrefix(mediatype, "multipart/") {
+		return Fail("not an mjpeg stream", errors.Errorf("content type %q", resp.Header.Get(contenttype)))
+	}
+
+	// the boundary is read from the stream instead of the content type,
+	// since some cameras declare it incorrectly (eg with an extra "--")
+	body := bufio.NewReader(resp.Body)
+	boundary, err := readBoundary(body)
+	if err != nil {
+		return Retryable("trouble reading stream", err)
+	}
+	parts := multipart.NewReader(io.MultiReader(strings.NewReader("--"+boundary+"\r\n"), body), boundary)
+
+	frame := m.Frame
+	if frame < 1 {
+		frame = 1
+	}
+	for i := 1; ; i++ {
+		part, err := parts.NextPart()
+		if err != nil {
+			return Retryable("trouble reading stream", errors.Wrapf(err, "frame %d", i))
+		}
+		if i < frame {
+			continue
+		}
+		s.Header = http.Header(part.Header)
+		s.Data, err = ioutil.ReadAll(io.LimitReader(part, maxFrameSize))
+		if err != nil {
+			return Retryable("trouble reading stream", errors.Wrapf(err, "frame %d", i))
+		}
+		return nil
+	}
+}
+
+// readBoundary reads the first boundary delimiter line of a multipart
+// body, returning the boundary without its leading "--".
+func readBoundary(r *bufio.Reader) (string, error) {
+	const maxLines = 10 // blank lines or preamble before the boundary
+	for i := 0; i < maxLines; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", errors.Wrap(err, "reading boundary")
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "--") && len(line) > 2 {
+			return line[2:], nil
+		}
+	}
+	return "", errors.New("no boundary at start of stream")
+}
@@ -0,0 +1,68 @@
+package pipeline
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMJPEG(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image":
+			fmt.Fprint(w, "image")
+			return
+		case "/bad":
+			// declares the boundary with an extra "--", like some cameras
+			w.Header().Set(contenttype, "multipart/x-mixed-replace; boundary=--frame")
+		default:
+			w.Header().Set(contenttype, "multipart/x-mixed-replace; boundary=frame")
+		}
+		// an endless stream, which stalls after 3 frames
+		for i := 1; ; i++ {
+			if i > 3 {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-time.After(time.Second):
+				}
+			}
+			_, err := fmt.Fprintf(w, "--frame\r\nContent-Type: image/jpeg\r\n\r\nframe %d\r\n", i)
+			if err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path  string
+		frame int
+		want  string
+	}{
+		{"/stream", 0, "frame 1"},
+		{"/stream", 3, "frame 3"},
+		{"/bad", 2, "frame 2"},
+	}
+	for _, tt := range tests {
+		s := &Scrape{URL: server.URL + tt.path}
+		err := MJPEG{Frame: tt.frame, Deadline: 5 * time.Second}.Run(s)
+		if err != nil || string(s.Data) != tt.want {
+			t.Errorf("%s frame %d: got %q, %v, want %q", tt.path, tt.frame, s.Data, err, tt.want)
+		}
+	}
+
+	// the stream stalls before frame 5
+	start := time.Now()
+	err := MJPEG{Frame: 5, Deadline: 200 * time.Millisecond}.Run(&Scrape{URL: server.URL + "/stream"})
+	if !IsRetryable(err) || time.Since(start) > time.Second {
+		t.Errorf("stalled stream got %v after %s, want retryable error at deadline", err, time.Since(start))
+	}
+
+	if err := (MJPEG{}).Run(&Scrape{URL: server.URL + "/image"}); err == nil || IsRetryable(err) {
+		t.Errorf("image got %v, want permanent error", err)
+	}
+}
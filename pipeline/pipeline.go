@@ -0,0 +1,359 @@
+// Package pipeline performs a scrape of a camera as a sequence of stages,
+// such as fetching, decoding, filtering, deduplicating, and writing an
+// image. The result of every scrape is recorded, whether or not its stages
+// succeed.
+//
+// The stages of each camera's pipeline are described by a Definition, which
+// is built into a Pipeline using a Factory for each kind of stage.
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/quillaja/mtcam/log"
+	"github.com/quillaja/mtcam/model"
+)
+
+// Scrape holds the state of a scrape as it passes through the stages of
+// a Pipeline. Each stage reads the fields set by earlier stages and sets
+// the fields it's responsible for.
+type Scrape struct {
+	Mountain model.Mountain
+	Camera   model.Camera
+	Time     time.Time // time of the scrape
+
+	URL    string      // url of the camera image
+	Header http.Header // headers of the response to the fetch
+	Data   []byte      // raw image data
+	Image  image.Image // decoded (and possibly filtered) image
+
+	// Record is the scrape record saved by the Pipeline's Recorder. Its
+	// Result and Detail are set by the Pipeline from the outcome of the
+	// stages, and its Filename is set by a stage which writes the image.
+	Record model.Scrape
+}
+
+// NewScrape creates a Scrape of cam at t. The scrape is recorded as a
+// failure unless the pipeline succeeds.
+func NewScrape(mt model.Mountain, cam model.Camera, t time.Time) *Scrape {
+	return &Scrape{
+		Mountain: mt,
+		Camera:   cam,
+		Time:     t,
+		Record: model.Scrape{
+			CameraID: cam.ID,
+			Created:  t,
+			Result:   model.Failure}}
+}
+
+// Validators returns the ETag and Last-Modified headers of the response
+// fetched by s, which make the next fetch of the camera conditional (see
+// Fetch). They're empty if the response didn't have them.
+func (s *Scrape) Validators() (etag, lastModified string) {
+	return s.Header.Get(etagHeader), s.Header.Get(lastModifiedHeader)
+}
+
+// String identifies the scrape in log messages.
+func (s *Scrape) String() string {
+	return fmt.Sprintf("(mtID=%d camID=%d)", s.Mountain.ID, s.Camera.ID)
+}
+
+// Stage is a step of a Pipeline.
+type Stage interface {
+	// Name identifies the stage in errors.
+	Name() string
+	// Run performs the stage on s. Returning an error stops the pipeline.
+	// Errors created by Fail and Retryable set the detail recorded
+	// with the scrape.
+	Run(s *Scrape) error
+}
+
+// StageFunc creates a Stage named name which calls run.
+func StageFunc(name string, run func(*Scrape) error) Stage {
+	return stageFunc{name: name, run: run}
+}
+
+type stageFunc struct {
+	name string
+	run  func(*Scrape) error
+}
+
+func (f stageFunc) Name() string { return f.name }
+
+func (f stageFunc) Run(s *Scrape) error { return f.run(s) }
+
+// Error is returned by a Pipeline when a stage fails.
+type Error struct {
+	Stage  string // name of the stage which failed
+	Result string // result recorded for the scrape, eg model.Failure
+	Detail string // detail recorded for the scrape
+	Err    error  // underlying error. may be nil
+	Retry  bool   // the scrape might succeed if retried
+	// Duplicate is set if the image is the same as an earlier image of
+	// the camera.
+	Duplicate bool
+	// Reason is why the scrape's content was rejected (eg ReasonTruncated),
+	// which is also at the start of the Detail. empty if it wasn't.
+	Reason string
+}
+
+func (e *Error) Error() string {
+	msg := e.Detail
+	if e.Stage != "" {
+		msg = e.Stage + ": " + msg
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Fail returns an error which stops a pipeline, recording the scrape as a
+// failure with detail. err may be nil.
+func Fail(detail string, err error) error {
+	return &Error{Result: model.Failure, Detail: detail, Err: err}
+}
+
+// Retryable is like Fail, but the scrape might succeed if retried (eg
+// after a network error).
+func Retryable(detail string, err error) error {
+	return &Error{Result: model.Failure, Detail: detail, Err: err, Retry: true}
+}
+
+// Duplicate is like Fail, but for a scrape whose image is the same as an
+// earlier image of the camera.
+func Duplicate(detail string) error {
+	return &Error{Result: model.Failure, Detail: detail, Duplicate: true}
+}
+
+// Unchanged returns an error which stops a pipeline because the camera's
+// image hasn't been modified since it was last fetched. The scrape is
+// recorded as model.Unchanged instead of a failure, and is a duplicate.
+func Unchanged(detail string) error {
+	return &Error{Result: model.Unchanged, Detail: detail, Duplicate: true}
+}
+
+// IsUnchanged reports if err is an Error created by Unchanged.
+func IsUnchanged(err error) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && e.Result == model.Unchanged
+}
+
+// IsDuplicate reports if err is an Error created by Duplicate or Unchanged.
+func IsDuplicate(err error) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && e.Duplicate
+}
+
+// Reasons for which downloaded content is rejected, by Validate (see
+// Invalid) or Inspect (see Reject).
+const (
+	ReasonContentType = "content-type" // Content-Type isn't an image
+	ReasonTruncated   = "truncated"    // fewer bytes than the Content-Length
+	ReasonNotImage    = "not-image"    // content isn't an image, eg an HTML page
+	ReasonNoEnd       = "no-end"       // JPEG or PNG without its end of image marker
+	ReasonTooSmall    = "too-small"    // image smaller than the minimum size
+	ReasonTooLarge    = "too-large"    // download larger than the maximum size
+
+	ReasonDark        = "dark"        // image too dark, eg at night
+	ReasonBlank       = "blank"       // image without contrast, eg a solid gray card
+	ReasonPlaceholder = "placeholder" // image matches a placeholder, eg "camera offline"
+)
+
+// Reasons for which a scrape is skipped (see Skip).
+const (
+	ReasonDiskFull = "disk-full" // too little free space to save the image
+)
+
+// Invalid returns an error which stops a pipeline because the downloaded
+// content was rejected for reason, recording the scrape as a failure with
+// the detail "invalid content: <reason>: <msg>". retry is set if the
+// content might be valid if downloaded again (eg if truncated).
+func Invalid(reason, msg string, retry bool) error {
+	return &Error{
+		Result: model.Failure,
+		Detail: "invalid content: " + reason + ": " + msg,
+		Reason: reason,
+		Retry:  retry}
+}
+
+// Reject returns an error which stops a pipeline because the image is
+// unusable for reason (eg ReasonDark), recording the scrape as
+// model.Rejected with the detail "rejected: <reason>: <msg>".
+func Reject(reason, msg string) error {
+	return &Error{
+		Result: model.Rejected,
+		Detail: "rejected: " + reason + ": " + msg,
+		Reason: reason}
+}
+
+// IsRejected reports if err is an Error created by Reject.
+func IsRejected(err error) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && e.Result == model.Rejected
+}
+
+// Skip returns an error which stops a pipeline before it scrapes anything
+// for reason (eg ReasonDiskFull), recording the scrape as model.Skipped
+// with the detail "skipped: <reason>: <msg>".
+func Skip(reason, msg string) error {
+	return &Error{
+		Result: model.Skipped,
+		Detail: "skipped: " + reason + ": " + msg,
+		Reason: reason}
+}
+
+// IsSkipped reports if err is an Error created by Skip.
+func IsSkipped(err error) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && e.Result == model.Skipped
+}
+
+// Reason returns the reason err rejected a scrape's content, or "" if err
+// isn't an Error created by Invalid or Reject.
+func Reason(err error) string {
+	if e, ok := errors.Cause(err).(*Error); ok {
+		return e.Reason
+	}
+	return ""
+}
+
+// IsRetryable reports if err is an Error which might not happen if the
+// scrape is retried.
+func IsRetryable(err error) bool {
+	e, ok := errors.Cause(err).(*Error)
+	return ok && e.Retry
+}
+
+// Pipeline runs the stages of a scrape and records the result.
+type Pipeline struct {
+	Stages []Stage
+	// Recorder saves the scrape's Record after the stages have run, even
+	// if they failed.
+	Recorder Stage
+}
+
+// Run runs each stage on s in order, stopping at the first to fail, and
+// then records s. It returns the *Error of the stage which failed, or the
+// error recording s.
+func (p *Pipeline) Run(s *Scrape) error {
+	var failed error
+	for _, stage := range p.Stages {
+		if err := stage.Run(s); err != nil {
+			failed = stageError(stage.Name(), err)
+			break
+		}
+	}
+	return p.record(s, failed)
+}
+
+// Abort records s as having failed with err without running any stages,
+// eg when the scrape couldn't be prepared. It returns err as an *Error.
+func (p *Pipeline) Abort(s *Scrape, err error) error {
+	return p.record(s, stageError("", err))
+}
+
+// record sets the result of s according to failed, then records s.
+func (p *Pipeline) record(s *Scrape, failed error) error {
+	if failed != nil {
+		e := failed.(*Error)
+		s.Record.Result, s.Record.Detail = e.Result, e.Detail
+	} else {
+		s.Record.Result, s.Record.Detail = model.Success, ""
+	}
+
+	if p.Recorder == nil {
+		return failed
+	}
+	if err := p.Recorder.Run(s); err != nil {
+		err = errors.Wrapf(err, "%s failed to record scrape", s)
+		if failed == nil {
+			return err
+		}
+		// the stage's error is more useful to the caller
+		log.Print(log.Critical, err)
+	}
+	return failed
+}
+
+// stageError converts err returned by the stage named name to an *Error.
+// An *Error wrapped by err (see errors.Cause) is copied, since the stage
+// may return it again.
+func stageError(name string, err error) error {
+	var e Error
+	if cause, ok := errors.Cause(err).(*Error); ok {
+		e = *cause
+	} else {
+		e = Error{Result: model.Failure, Detail: "failed", Err: err}
+	}
+	if e.Stage == "" {
+		e.Stage = name
+	}
+	if e.Result == "" {
+		e.Result = model.Failure
+	}
+	return &e
+}
+
+// StageDef describes a stage in a Definition.
+type StageDef struct {
+	Stage  string          `json:"stage"`            // name of the stage's Factory
+	Params json.RawMessage `json:"params,omitempty"` // passed to the Factory
+}
+
+// Definition describes the stages of a Pipeline, in order. Its JSON form is
+// an array like [{"stage": "fetch"}, {"stage": "crop", "params": {...}}].
+type Definition []StageDef
+
+// ParseDefinition parses the JSON form of a Definition. An empty string is
+// a nil Definition.
+func ParseDefinition(js string) (Definition, error) {
+	if js == "" {
+		return nil, nil
+	}
+	var def Definition
+	if err := json.Unmarshal([]byte(js), &def); err != nil {
+		return nil, errors.Wrap(err, "parsing pipeline definition")
+	}
+	return def, nil
+}
+
+// Factory creates a Stage from the params in its StageDef.
+type Factory func(params json.RawMessage) (Stage, error)
+
+// DecodeParams unmarshals params into v if there are any. Fields of v
+// which aren't in params are unchanged, so v can be filled with defaults.
+func DecodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	return json.Unmarshal(params, v)
+}
+
+// Build creates a Pipeline from def using factories, which maps a stage
+// name to the Factory which creates it, and recorder.
+func Build(def Definition, factories map[string]Factory, recorder Stage) (*Pipeline, error) {
+	p := &Pipeline{
+		Stages:   make([]Stage, 0, len(def)),
+		Recorder: recorder}
+	for i, sd := range def {
+		factory, ok := factories[sd.Stage]
+		if !ok {
+			return nil, errors.Errorf("stage %d: no stage named %q", i+1, sd.Stage)
+		}
+		stage, err := factory(sd.Params)
+		if err != nil {
+			return nil, errors.Wrapf(err, "stage %d (%s)", i+1, sd.Stage)
+		}
+		p.Stages = append(p.Stages, stage)
+	}
+	return p, nil
+}
@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/quillaja/mtcam/model"
+)
+
+func TestPipelineRun(t *testing.T) {
+	var ran []string
+	stage := func(name string, err error) Stage {
+		return StageFunc(name, func(*Scrape) error {
+			ran = append(ran, name)
+			return err
+		})
+	}
+	var recorded []model.Scrape
+	recorder := StageFunc("record", func(s *Scrape) error {
+		recorded = append(recorded, s.Record)
+		return nil
+	})
+
+	// an error returned by a stage every time it runs
+	shared := Reject(ReasonDark, "night")
+
+	tests := []struct {
+		name   string
+		stages []Stage
+		ran    int    // number of stages run
+		result string // recorded result
+		detail string
+		retry  bool
+	}{
+		{"success", []Stage{stage("a", nil), stage("b", nil)}, 2, model.Success, "", false},
+		{"fail", []Stage{stage("a", Fail("bad", nil)), stage("b", nil)}, 1, model.Failure, "bad", false},
+		{"retryable", []Stage{stage("a", nil), stage("b", Retryable("flaky", errors.New("x")))}, 2, model.Failure, "flaky", true},
+		{"plain error", []Stage{stage("a", errors.New("x"))}, 1, model.Failure, "failed", false},
+		{"wrapped", []Stage{stage("a", errors.Wrap(Retryable("flaky", nil), "context"))}, 1, model.Failure, "flaky", true},
+		{"shared", []Stage{stage("a", nil), stage("b", shared)}, 2, model.Rejected, "rejected: dark: night", false},
+		{"skipped", []Stage{stage("a", Skip(ReasonDiskFull, "1 MB free")), stage("b", nil)}, 1, model.Skipped, "skipped: disk-full: 1 MB free", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran, recorded = nil, nil
+			p := &Pipeline{Stages: tt.stages, Recorder: recorder}
+			err := p.Run(NewScrape(model.Mountain{}, model.Camera{ID: 1}, time.Now()))
+
+			if len(ran) != tt.ran {
+				t.Errorf("ran %v, want %d stages", ran, tt.ran)
+			}
+			if len(recorded) != 1 {
+				t.Fatalf("recorded %d scrapes, want 1", len(recorded))
+			}
+			if rec := recorded[0]; rec.Result != tt.result || rec.Detail != tt.detail || rec.CameraID != 1 {
+				t.Errorf("recorded %+v, want %s %q", rec, tt.result, tt.detail)
+			}
+			if (err != nil) != (tt.result != model.Success) || IsRetryable(err) != tt.retry {
+				t.Errorf("got error %v, want retry %t", err, tt.retry)
+			}
+			if e, ok := err.(*Error); ok && e.Stage != ran[len(ran)-1] {
+				t.Errorf("error from stage %q, want %q", e.Stage, ran[len(ran)-1])
+			}
+		})
+	}
+	if e := shared.(*Error); e.Stage != "" {
+		t.Errorf("stage's error modified: stage %q", e.Stage)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	type params struct{ N, M int }
+	var got []params
+	factories := map[string]Factory{
+		"n": func(raw json.RawMessage) (Stage, error) {
+			p := params{N: 1, M: 2} // defaults
+			err := DecodeParams(raw, &p)
+			got = append(got, p)
+			return StageFunc("n", func(*Scrape) error { return nil }), err
+		},
+	}
+
+	def, err := ParseDefinition(`[{"stage": "n"}, {"stage": "n", "params": {"M": 5}}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := Build(def, factories, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Stages) != 2 || got[0] != (params{1, 2}) || got[1] != (params{1, 5}) {
+		t.Errorf("built %d stages with params %v", len(p.Stages), got)
+	}
+
+	for _, js := range []string{`[{"stage": "missing"}]`, `[{"stage": "n", "params": {"N": "x"}}]`} {
+		def, err := ParseDefinition(js)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Build(def, factories, nil); err == nil {
+			t.Errorf("built %s without error", js)
+		}
+	}
+	if _, err := ParseDefinition(`{`); err == nil {
+		t.Error("parsed invalid definition")
+	}
+	if def, err := ParseDefinition(""); def != nil || err != nil {
+		t.Errorf("empty definition parsed as %v, %v", def, err)
+	}
+}
@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// start and end of image markers
+var (
+	jpegStart = []byte{0xFF, 0xD8}
+	jpegEnd   = []byte{0xFF, 0xD9}
+	pngStart  = []byte("\x89PNG\r\n\x1a\n")
+	pngEnd    = []byte("IEND\xaeB`\x82") // type and crc of the final chunk
+)
+
+// Validate is a Stage which rejects downloaded content which isn't a
+// complete image before it's decoded, eg an HTML error page or a truncated
+// JPEG. Each check rejects the content with an Invalid error of a
+// different reason. The download size is limited by Fetch.
+type Validate struct {
+	// ContentTypes are the substrings of which the Content-Type of the
+	// download must contain one. Empty is "image". A download without a
+	// Content-Type isn't rejected.
+	ContentTypes []string
+	// MinWidth and MinHeight are the minimum size of the image. 0 is no
+	// minimum.
+	MinWidth  int
+	MinHeight int
+}
+
+func (Validate) Name() string { return "validate" }
+
+func (v Validate) Run(s *Scrape) error {
+	if ct := s.Header.Get(contenttype); ct != "" && !v.allowed(ct) {
+		return Invalid(ReasonContentType, ct, true)
+	}
+
+	if cl := s.Header.Get(contentlength); cl != "" {
+		n, err := strconv.Atoi(cl)
+		if err == nil && n != len(s.Data) {
+			return Invalid(ReasonTruncated, fmt.Sprintf("read %d of %d bytes", len(s.Data), n), true)
+		}
+	}
+
+	if sniffed := http.DetectContentType(s.Data); !strings.HasPrefix(sniffed, "image/") {
+		return Invalid(ReasonNotImage, "content looks like "+sniffed, true)
+	}
+
+	// some cameras pad images, so ignore trailing zeros and whitespace
+	data := bytes.TrimRight(s.Data, "\x00\r\n\t ")
+	switch {
+	case bytes.HasPrefix(data, jpegStart) && !bytes.HasSuffix(data, jpegEnd):
+		return Invalid(ReasonNoEnd, "jpeg without end of image marker", true)
+	case bytes.HasPrefix(data, pngStart) && !bytes.HasSuffix(data, pngEnd):
+		return Invalid(ReasonNoEnd, "png without IEND chunk", true)
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(s.Data))
+	if err != nil {
+		return Invalid(ReasonNotImage, err.Error(), true)
+	}
+	if cfg.Width < v.MinWidth || cfg.Height < v.MinHeight {
+		return Invalid(ReasonTooSmall, fmt.Sprintf("%s is %dx%d, smaller than %dx%d",
+			format, cfg.Width, cfg.Height, v.MinWidth, v.MinHeight), false)
+	}
+	return nil
+}
+
+// allowed reports if the content type contains one of ContentTypes.
+func (v Validate) allowed(contentType string) bool {
+	types := v.ContentTypes
+	if len(types) == 0 {
+		types = []string{"image"}
+	}
+	for _, t := range types {
+		if strings.Contains(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package pipeline
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+func TestValidate(t *testing.T) {
+	encode := func(format imaging.Format) []byte {
+		buf := new(bytes.Buffer)
+		if err := imaging.Encode(buf, testImage(64, 48), format); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	jpeg, png := encode(imaging.JPEG), encode(imaging.PNG)
+	padded := append(append([]byte{}, jpeg...), 0, 0, '\n')
+
+	tests := []struct {
+		name   string
+		ct     string
+		cl     int // content length header. -1 is none
+		data   []byte
+		reason string
+	}{
+		{"jpeg", "image/jpeg", len(jpeg), jpeg, ""},
+		{"png without headers", "", -1, png, ""},
+		{"padded jpeg", "image/jpeg", -1, padded, ""},
+		{"html page", "text/html; charset=utf-8", -1, []byte("<html>login</html>"), ReasonContentType},
+		{"html as image", "image/jpeg", -1, []byte("<html>login</html>"), ReasonNotImage},
+		{"short read", "image/jpeg", len(jpeg) + 100, jpeg, ReasonTruncated},
+		{"truncated jpeg", "", -1, jpeg[:len(jpeg)/2], ReasonNoEnd},
+		{"truncated png", "", -1, png[:len(png)-20], ReasonNoEnd},
+		{"too small", "image/png", -1, png, ReasonTooSmall},
+	}
+	for _, tt := range tests {
+		v := Validate{MinWidth: 32, MinHeight: 32}
+		if tt.reason == ReasonTooSmall {
+			v.MinHeight = 100
+		}
+		s := &Scrape{Header: http.Header{}, Data: tt.data}
+		if tt.ct != "" {
+			s.Header.Set(contenttype, tt.ct)
+		}
+		if tt.cl >= 0 {
+			s.Header.Set(contentlength, strconv.Itoa(tt.cl))
+		}
+		err := v.Run(s)
+		if Reason(err) != tt.reason || (tt.reason == "") != (err == nil) {
+			t.Errorf("%s: got %v, want reason %q", tt.name, err, tt.reason)
+		}
+	}
+}
+
+func TestFetchMaxSize(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/chunked" {
+			// no content length
+			w.Write([]byte("0123456789"))
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	for _, path := range []string{"/", "/chunked"} {
+		s := &Scrape{URL: server.URL + path}
+		if err := (Fetch{MaxSize: 5}).Run(s); Reason(err) != ReasonTooLarge || IsRetryable(err) {
+			t.Errorf("%s: got %v, want permanent too large error", path, err)
+		}
+		if err := (Fetch{MaxSize: 20}).Run(s); err != nil {
+			t.Errorf("%s: got %v", path, err)
+		}
+	}
+}
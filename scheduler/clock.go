@@ -0,0 +1,183 @@
+package scheduler
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and timers to a Scheduler, so that
+// a fake clock can be used in place of the system clock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer creates a Timer which fires after d.
+	NewTimer(d time.Duration) Timer
+	// After waits for d to elapse and then sends the current time on the
+	// returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+// Timer is a single event timer, like time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is delivered.
+	C() <-chan time.Time
+	// Stop prevents the Timer from firing. It reports if the call
+	// stopped the timer.
+	Stop() bool
+	// Reset changes the timer to fire after d. It reports if the timer
+	// had been active.
+	Reset(d time.Duration) bool
+}
+
+// SystemClock is the Clock using the system time.
+var SystemClock Clock = systemClock{}
+
+// systemClock implements Clock with the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// systemTimer implements Timer with time.Timer.
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+// WithClock configures a Scheduler to use c instead of the system clock.
+func WithClock(c Clock) Option {
+	return func(s *Scheduler) {
+		s.clock = c
+		s.queue.clock = c
+	}
+}
+
+// FakeClock is a Clock whose time only changes when it is advanced. Timers
+// fire when the clock is advanced to or past their deadline. It's intended
+// for testing a whole day's schedule quickly and deterministically.
+type FakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+	m      sync.Mutex
+}
+
+// NewFakeClock creates a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the clock's current time.
+func (c *FakeClock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.now
+}
+
+// NewTimer creates a Timer which fires when the clock is advanced by d.
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.m.Lock()
+	defer c.m.Unlock()
+	t := &fakeTimer{
+		clock: c,
+		c:     make(chan time.Time, 1)}
+	c.start(t, d)
+	return t
+}
+
+// After returns a channel on which the time is sent when the clock is
+// advanced by d.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// Advance moves the clock forward by d, firing any timers whose deadline
+// is reached.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.now = c.now.Add(d)
+
+	active := c.timers[:0]
+	for _, t := range c.timers {
+		if t.deadline.After(c.now) {
+			active = append(active, t)
+			continue
+		}
+		t.fire(c.now)
+	}
+	c.timers = active
+}
+
+// Set moves the clock forward to t. It does nothing if t is before the
+// clock's current time.
+func (c *FakeClock) Set(t time.Time) {
+	if d := t.Sub(c.Now()); d > 0 {
+		c.Advance(d)
+	}
+}
+
+// Timers returns the number of timers which have not yet fired.
+func (c *FakeClock) Timers() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return len(c.timers)
+}
+
+// start sets t to fire after d, immediately if d <= 0.
+// c.m must be held by the caller.
+func (c *FakeClock) start(t *fakeTimer, d time.Duration) {
+	t.deadline = c.now.Add(d)
+	if d <= 0 {
+		t.fire(c.now)
+		return
+	}
+	c.timers = append(c.timers, t)
+}
+
+// stop removes t from the clock's active timers, reporting if it was active.
+// c.m must be held by the caller.
+func (c *FakeClock) stop(t *fakeTimer) bool {
+	for i, active := range c.timers {
+		if active == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// fakeTimer is a Timer controlled by a FakeClock.
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	c        chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.m.Lock()
+	defer t.clock.m.Unlock()
+	return t.clock.stop(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.m.Lock()
+	defer t.clock.m.Unlock()
+	active := t.clock.stop(t)
+	t.clock.start(t, d)
+	return active
+}
+
+// fire sends now on the timer's channel, unless a previous time hasn't
+// been received yet.
+func (t *fakeTimer) fire(now time.Time) {
+	select {
+	case t.c <- now:
+	default:
+	}
+}
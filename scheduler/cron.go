@@ -0,0 +1,178 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Cron is a Schedule parsed from a standard 5 field cron expression
+// ("minute hour day-of-month month day-of-week") evaluated in loc.
+//
+// Each field may be "*", a number, a range "a-b", or a list of these
+// separated by commas. Any of "*" or a range may be followed by a step
+// "/n". Day-of-week is 0-7, where both 0 and 7 are Sunday. As in standard
+// cron, if both day-of-month and day-of-week are restricted, a day matching
+// either runs the task.
+//
+// Times repeated when daylight saving time ends run only once. Times
+// skipped when it starts don't run.
+func Cron(expr string, loc *time.Location) (Schedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("cron %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	c := &cron{expr: expr, loc: loc}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, errors.Wrapf(err, "cron %q minute", expr)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, errors.Wrapf(err, "cron %q hour", expr)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, errors.Wrapf(err, "cron %q day-of-month", expr)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, errors.Wrapf(err, "cron %q month", expr)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, errors.Wrapf(err, "cron %q day-of-week", expr)
+	}
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	c.anyDom = fields[2] == "*"
+	c.anyDow = fields[4] == "*"
+
+	return c, nil
+}
+
+// cron is a parsed cron expression. Each field is a set of allowed values
+// indexed by the value.
+type cron struct {
+	expr                     string
+	loc                      *time.Location
+	minute, hour, dom, month []bool
+	dow                      []bool
+	anyDom, anyDow           bool
+}
+
+// maxCronSearch limits how far ahead Next() looks for a matching time,
+// for expressions like "0 0 31 2 *" which never match.
+const maxCronSearch = 5 * 366 * 24 * time.Hour
+
+func (c *cron) Next(t time.Time) time.Time {
+	t = t.In(c.loc)
+	// start at the next whole minute
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	limit := t.Add(maxCronSearch)
+	midnight := daily{loc: c.loc}
+
+	for t.Before(limit) {
+		switch {
+		case !c.month[t.Month()]:
+			t = midnight.on(t.Year(), t.Month()+1, 1)
+
+		case !c.dayMatches(t):
+			t = midnight.on(t.Year(), t.Month(), t.Day()+1)
+
+		case !c.hour[t.Hour()]:
+			// add an hour to the start of this hour instead of using
+			// time.Date(), which is ambiguous when daylight saving time ends
+			t = t.Add(time.Hour - time.Duration(t.Minute())*time.Minute)
+
+		case !c.minute[t.Minute()]:
+			t = t.Add(time.Minute)
+
+		case repeatedWallTime(t):
+			// already matched an hour ago, before daylight saving time ended
+			t = t.Add(time.Minute)
+
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// dayMatches reports if t's day matches the day-of-month and day-of-week
+// fields.
+func (c *cron) dayMatches(t time.Time) bool {
+	dom, dow := c.dom[t.Day()], c.dow[t.Weekday()]
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+func (c *cron) String() string {
+	return fmt.Sprintf("cron %q %s", c.expr, c.loc)
+}
+
+// repeatedWallTime reports if the wall clock showed the same time an hour
+// before t, as happens when daylight saving time ends.
+func repeatedWallTime(t time.Time) bool {
+	before := t.Add(-time.Hour)
+	return before.Day() == t.Day() && before.Hour() == t.Hour() && before.Minute() == t.Minute()
+}
+
+// parseCronField parses a single cron field into a set of allowed values
+// in [min, max].
+func parseCronField(field string, min, max int) ([]bool, error) {
+	set := make([]bool, max+1)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, errors.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return nil, errors.Errorf("invalid range %q", part)
+			}
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, errors.Errorf("invalid value %q", part)
+			}
+			lo, hi = n, n
+			if step > 1 {
+				hi = max // "n/step" means from n to max by step
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return nil, errors.Errorf("%q out of range [%d, %d]", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+
+	return set, nil
+}
@@ -0,0 +1,70 @@
+package scheduler
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// taskHeap is a min-heap of items ordered by the time they're due. Items
+// due at the same time are ordered by ID, which increases as tasks are
+// added, so they're processed first-in first-out.
+//
+// It implements heap.Interface, and should only be modified using the
+// functions in container/heap or its own methods.
+type taskHeap []item
+
+func (h taskHeap) Len() int { return len(h) }
+
+func (h taskHeap) Less(i, j int) bool {
+	wi, wj := h[i].task.When(), h[j].task.When()
+	if wi.Equal(wj) {
+		return h[i].id < h[j].id
+	}
+	return wi.Before(wj)
+}
+
+func (h taskHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *taskHeap) Push(x interface{}) { *h = append(*h, x.(item)) }
+
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	old[n-1] = item{} // clear reference to popped task
+	*h = old[:n-1]
+	return it
+}
+
+// push adds it to the heap.
+func (h *taskHeap) push(it item) { heap.Push(h, it) }
+
+// pop removes and returns the earliest item.
+func (h *taskHeap) pop() item { return heap.Pop(h).(item) }
+
+// peek returns the earliest item without removing it. The heap must
+// not be empty.
+func (h taskHeap) peek() item { return h[0] }
+
+// filter removes the items for which remove returns true.
+func (h *taskHeap) filter(remove func(item) bool) {
+	keep := (*h)[:0]
+	for _, it := range *h {
+		if !remove(it) {
+			keep = append(keep, it)
+		}
+	}
+	for i := len(keep); i < len(*h); i++ {
+		(*h)[i] = item{} // clear references to removed tasks
+	}
+	*h = keep
+	heap.Init(h)
+}
+
+// sorted returns a copy of the items in the order they'll be popped.
+func (h taskHeap) sorted() []item {
+	items := make(taskHeap, len(h))
+	copy(items, h)
+	sort.Sort(items)
+	return items
+}
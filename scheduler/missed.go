@@ -0,0 +1,142 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+)
+
+// MissedPolicy decides which overdue tasks are run when the queue is
+// processed, such as after the computer was suspended or the process
+// stalled. The zero value runs all overdue tasks.
+type MissedPolicy struct {
+	// MaxAge skips tasks which are overdue by more than MaxAge.
+	// 0 doesn't skip any tasks.
+	MaxAge time.Duration
+	// Key, if not nil, runs only the latest of the tasks due at once
+	// which have the same key. Tasks with an empty key are all run.
+	Key func(Task) string
+}
+
+// RunAll is a MissedPolicy which runs every overdue task.
+func RunAll() MissedPolicy {
+	return MissedPolicy{}
+}
+
+// RunLatest is a MissedPolicy which runs only the latest of the overdue
+// tasks with the same key.
+func RunLatest(key func(Task) string) MissedPolicy {
+	return MissedPolicy{Key: key}
+}
+
+// SkipOlderThan is a MissedPolicy which skips tasks overdue by more than d.
+func SkipOlderThan(d time.Duration) MissedPolicy {
+	return MissedPolicy{MaxAge: d}
+}
+
+// Missable is implemented by Tasks which have their own MissedPolicy,
+// overriding the Scheduler's. ok is false if the task uses the Scheduler's.
+type Missable interface {
+	MissedPolicy() (p MissedPolicy, ok bool)
+}
+
+// WithMissedPolicy sets the MissedPolicy of a task created by NewTask,
+// NewTaggedTask, or NewPersistentTask and returns it. Other Tasks are
+// returned unchanged; they can implement Missable instead.
+func WithMissedPolicy(t Task, p MissedPolicy) Task {
+	switch t := t.(type) {
+	case *task:
+		t.missed = &p
+	case *persistentTask:
+		t.missed = &p
+	}
+	return t
+}
+
+func (t *task) MissedPolicy() (MissedPolicy, bool) {
+	if t.missed == nil {
+		return MissedPolicy{}, false
+	}
+	return *t.missed, true
+}
+
+// OnMissed configures the MissedPolicy used for overdue Tasks which
+// don't have their own. The default is RunAll().
+func OnMissed(p MissedPolicy) Option {
+	return func(s *Scheduler) {
+		s.queue.missed = p
+	}
+}
+
+// ReportSkipped configures a Scheduler to call report with each Task
+// skipped because of a MissedPolicy, and the reason it was skipped.
+func ReportSkipped(report func(e Entry, reason string)) Option {
+	return func(s *Scheduler) {
+		s.queue.skipped = report
+	}
+}
+
+// skipped is a task which will not be run, and why.
+type skipped struct {
+	entry  Entry
+	reason string
+}
+
+// applyMissed removes the tasks in due (all due by now) which are skipped
+// by their MissedPolicy. It returns the tasks to run and those skipped.
+func (q *TaskQueue) applyMissed(due []item, now time.Time) (run []item, skips []skipped) {
+	policy := func(it item) MissedPolicy {
+		if m, ok := it.task.(Missable); ok {
+			if p, ok := m.MissedPolicy(); ok {
+				return p
+			}
+		}
+		return q.missed
+	}
+
+	// walk backwards so the latest task for each key is seen first
+	skip := make([]bool, len(due))
+	latest := make(map[string]item)
+	for i := len(due) - 1; i >= 0; i-- {
+		it := due[i]
+		p := policy(it)
+
+		late := now.Sub(it.task.When())
+		if p.MaxAge > 0 && late > p.MaxAge {
+			skip[i] = true
+			skips = append(skips, skipped{
+				entry:  newEntry(it.id, it.task, Queued),
+				reason: fmt.Sprintf("overdue by %s", late.Round(time.Second))})
+			continue
+		}
+
+		if p.Key == nil {
+			continue
+		}
+		key := p.Key(it.task)
+		if key == "" {
+			continue
+		}
+		if later, ok := latest[key]; ok {
+			skip[i] = true
+			skips = append(skips, skipped{
+				entry: newEntry(it.id, it.task, Queued),
+				reason: fmt.Sprintf("superseded by task %d due at %s",
+					later.id, later.task.When().Format(time.RFC3339))})
+			continue
+		}
+		latest[key] = it
+	}
+
+	run = make([]item, 0, len(due))
+	for i, it := range due {
+		if !skip[i] {
+			run = append(run, it)
+		}
+	}
+
+	// report skips in the order the tasks were due
+	for i, j := 0, len(skips)-1; i < j; i, j = i+1, j-1 {
+		skips[i], skips[j] = skips[j], skips[i]
+	}
+	return run, skips
+}
@@ -0,0 +1,210 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Record is the serializable form of a Persistent Task.
+type Record struct {
+	Kind string    // identifies the Factory used to recreate the task
+	Args []byte    // JSON encoded task arguments
+	When time.Time // time the task is due
+
+	// Attempt is the attempt number of a task which is being retried,
+	// and First the time its first attempt was due. Attempt is 0 and First
+	// is zero if the task isn't a retry.
+	Attempt int
+	First   time.Time
+}
+
+// Persistent is implemented by Tasks that can be saved to a Store and
+// recreated later by the Factory registered for their Kind.
+type Persistent interface {
+	Task
+	// Kind returns the name of the Factory which recreates the task.
+	Kind() string
+	// Args returns the JSON encoded arguments needed to recreate the task.
+	Args() ([]byte, error)
+}
+
+// Factory recreates a Task from its Record.
+type Factory func(rec Record) (Task, error)
+
+// Store saves and loads the pending tasks of a Scheduler.
+type Store interface {
+	// SaveTasks replaces all previously saved records with recs.
+	SaveTasks(recs []Record) error
+	// LoadTasks returns all saved records.
+	LoadTasks() ([]Record, error)
+}
+
+// NewPersistentTask creates a task with tags that calls try at when, and
+// which can be saved to a Store. args must be able to be marshaled to JSON.
+// Tags are not saved, so the task's Factory must recreate them. If try
+// returns an error, the task may be retried according to its RetryPolicy.
+func NewPersistentTask(when time.Time, kind string, args interface{}, tags Tags, try func(time.Time) error) Task {
+	return &persistentTask{
+		task: task{
+			when: when,
+			tags: tags,
+			try:  try},
+		kind: kind,
+		args: args}
+}
+
+// persistentTask is a task which implements Persistent.
+type persistentTask struct {
+	task
+	kind string
+	args interface{}
+}
+
+func (t *persistentTask) Kind() string { return t.kind }
+
+func (t *persistentTask) Args() ([]byte, error) { return json.Marshal(t.args) }
+
+func (t *persistentTask) String() string {
+	return fmt.Sprintf("%s %s", t.kind, t.When())
+}
+
+// PersistTo configures a Scheduler to save its Persistent tasks to store
+// at most every d while running, and once more when stopping. factories maps
+// a task Kind to the Factory used by Restore() to recreate the task.
+func PersistTo(store Store, d time.Duration, factories map[string]Factory) Option {
+	return func(s *Scheduler) {
+		s.store = store
+		s.persistEvery = d
+		s.factories = factories
+	}
+}
+
+// Save writes all Persistent tasks currently in the queue to the Scheduler's
+// Store. Tasks which are not Persistent are not saved. The queue is only
+// locked while its tasks are read, so adding tasks doesn't wait for the
+// Store. If the save fails, the queue is still dirty and is saved again by
+// the next persist.
+func (s *Scheduler) Save() error {
+	if s.store == nil {
+		return nil
+	}
+
+	// saves are serialized so that an older snapshot of the queue can't
+	// overwrite a newer one
+	s.saveMutex.Lock()
+	defer s.saveMutex.Unlock()
+
+	// changes made after the snapshot mark the queue dirty again
+	s.persistMutex.Lock()
+	s.dirty = false
+	recs, err := s.records()
+	s.persistMutex.Unlock()
+
+	if err == nil {
+		err = s.store.SaveTasks(recs)
+		if err != nil {
+			err = errors.Wrapf(err, "saving %d tasks", len(recs))
+		}
+	}
+	if err != nil {
+		s.markDirty() // try again next time
+		return err
+	}
+	return nil
+}
+
+// records makes the records of the Persistent tasks currently in the queue.
+func (s *Scheduler) records() ([]Record, error) {
+	tasks := s.queue.Tasks()
+	recs := make([]Record, 0, len(tasks))
+	for _, t := range tasks {
+		// a retry is saved as the original task, due at the retry time
+		rec := Record{When: t.When()}
+		if r, ok := t.(*retryTask); ok {
+			t = r.Task
+			rec.Attempt, rec.First = r.attempt, r.first
+		}
+
+		p, ok := t.(Persistent)
+		if !ok {
+			continue
+		}
+		args, err := p.Args()
+		if err != nil {
+			return nil, errors.Wrapf(err, "marshaling args of %s task", p.Kind())
+		}
+		rec.Kind, rec.Args = p.Kind(), args
+		recs = append(recs, rec)
+	}
+	return recs, nil
+}
+
+// Restore loads the tasks previously saved in the Scheduler's Store and adds
+// them to the queue. It returns the records successfully restored. A record
+// of a retry is restored as a retry of the task its Factory recreates, so the
+// task doesn't recur and its RetryPolicy applies to the remaining attempts.
+// Records which could not be restored are reported in the error, but do not
+// prevent the remaining records from being restored.
+func (s *Scheduler) Restore() ([]Record, error) {
+	if s.store == nil {
+		return nil, nil
+	}
+
+	recs, err := s.store.LoadTasks()
+	if err != nil {
+		return nil, errors.Wrap(err, "loading tasks")
+	}
+
+	restored := make([]Record, 0, len(recs))
+	var failed int
+	var lastErr error
+	for _, rec := range recs {
+		factory, ok := s.factories[rec.Kind]
+		if !ok {
+			failed++
+			lastErr = errors.Errorf("no factory for task kind %q", rec.Kind)
+			continue
+		}
+		t, err := factory(rec)
+		if err != nil {
+			failed++
+			lastErr = errors.Wrapf(err, "recreating %s task", rec.Kind)
+			continue
+		}
+		if rec.Attempt > 0 {
+			t = &retryTask{
+				Task:    t,
+				when:    rec.When,
+				attempt: rec.Attempt,
+				first:   rec.First}
+		}
+		s.Add(t)
+		restored = append(restored, rec)
+	}
+
+	if failed > 0 {
+		return restored, errors.Errorf("%d of %d tasks not restored (last error: %s)",
+			failed, len(recs), lastErr)
+	}
+	return restored, nil
+}
+
+// markDirty notes that the queue has changed since it was last saved.
+func (s *Scheduler) markDirty() {
+	if s.store == nil {
+		return
+	}
+	s.persistMutex.Lock()
+	s.dirty = true
+	s.persistMutex.Unlock()
+}
+
+// isDirty reports if the queue has changed since it was last saved.
+func (s *Scheduler) isDirty() bool {
+	s.persistMutex.Lock()
+	defer s.persistMutex.Unlock()
+	return s.dirty
+}
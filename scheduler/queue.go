@@ -2,8 +2,8 @@ package scheduler
 
 import (
 	"fmt"
+	"sort"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
@@ -15,101 +15,356 @@ type Task interface {
 	Run(time.Time)
 }
 
+// ID identifies a Task added to a TaskQueue.
+type ID uint64
+
+// Tags are labels (eg "camera": "12") attached to a Task, used to find
+// and describe the task once it's in a queue.
+type Tags map[string]string
+
+// Tagged is implemented by Tasks which carry Tags.
+type Tagged interface {
+	Tags() Tags
+}
+
 // NewTask creates a task that calls run at when.
 func NewTask(when time.Time, run func(time.Time)) Task {
 	return &task{
 		when: when,
-		run:  run}
+		try:  infallible(run)}
 }
 
-// task is a basic struct implementing the Task interface
-// available for convenience.
+// NewTaggedTask creates a task with tags that calls run at when.
+func NewTaggedTask(when time.Time, tags Tags, run func(time.Time)) Task {
+	return &task{
+		when: when,
+		tags: tags,
+		try:  infallible(run)}
+}
+
+// NewFallibleTask creates a task with tags that calls try at when. If try
+// returns an error, the task may be retried according to its RetryPolicy.
+func NewFallibleTask(when time.Time, tags Tags, try func(time.Time) error) Task {
+	return &task{
+		when: when,
+		tags: tags,
+		try:  try}
+}
+
+// infallible adapts run to a function which never returns an error.
+func infallible(run func(time.Time)) func(time.Time) error {
+	if run == nil {
+		return nil
+	}
+	return func(when time.Time) error {
+		run(when)
+		return nil
+	}
+}
+
+// task is a basic struct implementing the Task, Tagged, and Fallible
+// interfaces available for convenience.
 type task struct {
-	when time.Time
-	run  func(time.Time)
+	when   time.Time
+	tags   Tags
+	try    func(time.Time) error
+	missed *MissedPolicy
+	retry  *RetryPolicy
+
+	// schedule is set for recurring tasks
+	schedule Schedule
 }
 
 func (t *task) When() time.Time { return t.when }
 
-func (t *task) Run(when time.Time) { t.run(when) }
+func (t *task) Run(when time.Time) { t.try(when) }
+
+func (t *task) TryRun(when time.Time) error { return t.try(when) }
+
+func (t *task) Tags() Tags { return t.tags }
 
 func (t *task) String() string {
 	return t.When().String()
 }
 
-// TaskQueue is a concurrent-safe queue of tasks.
+// States of a Task in a TaskQueue.
+const (
+	Queued  = "queued"  // not yet due
+	Waiting = "waiting" // due, but waiting for a worker
+	Running = "running" // currently running
+)
+
+// Entry describes a Task in a TaskQueue.
+type Entry struct {
+	ID    ID        `json:"id"`
+	When  time.Time `json:"when"`
+	Tags  Tags      `json:"tags,omitempty"`
+	State string    `json:"state"`
+}
+
+// newEntry creates an Entry for t.
+func newEntry(id ID, t Task, state string) Entry {
+	e := Entry{
+		ID:    id,
+		When:  t.When(),
+		State: state}
+	if tagged, ok := t.(Tagged); ok {
+		e.Tags = tagged.Tags()
+	}
+	return e
+}
+
+// TaskQueue is a concurrent-safe queue of tasks. Tasks are kept in a
+// min-heap by the time they're due, so adding a task is O(log n). Tasks due
+// at the same time are processed in the order they were added.
+//
+// Tasks which are due but can't be run because of the limits on workers
+// or tasks per key wait (in order) until a running task finishes.
 type TaskQueue struct {
-	queue   []Task
+	queue   taskHeap
+	waiting []item
+	running map[ID]item
+	lastID  ID
+	clock   Clock
 	m       sync.Mutex
-	running int64
 	wg      sync.WaitGroup
+	stopped bool
+
+	// concurrency limits. 0 is unlimited.
+	workers    int
+	perKey     int
+	key        func(Task) string
+	keyRunning map[string]int
+
+	// policy for overdue tasks
+	missed  MissedPolicy
+	skipped func(Entry, string)
+
+	// policy for failed tasks
+	retry   RetryPolicy
+	results func(Result)
+
+	// called when the queue changes other than by Append or Process
+	changed func()
+}
+
+// item is a Task in the queue, its ID, and (once due) its key.
+type item struct {
+	id   ID
+	task Task
+	key  string
 }
 
 // NewTaskQueue creates a new TaskQueue.
 func NewTaskQueue() *TaskQueue {
 	return &TaskQueue{
-		queue: []Task{}}
+		queue:      taskHeap{},
+		running:    make(map[ID]item),
+		clock:      SystemClock,
+		keyRunning: make(map[string]int)}
 }
 
-// Append inserts a Task into the queue.
-func (q *TaskQueue) Append(t Task) {
+// Append inserts a Task into the queue, returning the ID assigned to it.
+func (q *TaskQueue) Append(t Task) ID {
 	q.m.Lock()
 	defer q.m.Unlock()
 
-	// find index for insertion
-	var i int
-	for ; i < len(q.queue) && q.queue[i].When().Before(t.When()); i++ {
-	}
-
-	q.queue = append(q.queue, nil)   // grow queue with zero value
-	copy(q.queue[i+1:], q.queue[i:]) // shift contents up one index
-	q.queue[i] = t                   // insert item
+	q.lastID++
+	q.queue.push(item{id: q.lastID, task: t})
+	return q.lastID
 }
 
-// Process calls Task.Run() on all tasks due.
+// Process calls Task.Run() on all tasks due, subject to the
+// concurrency limits of the queue.
+//
+// Overdue tasks may be skipped instead of run, according to their
+// MissedPolicy. Skipped tasks are reported after the queue is unlocked.
 func (q *TaskQueue) Process() {
 	q.m.Lock()
-	defer q.m.Unlock()
 
-	now := time.Now()
-	var front int
-	for i := 0; i < len(q.queue); i++ {
-		if q.queue[i].When().Before(now) { // TODO: what about tasks older than a certain duration?
-
-			q.wg.Add(1)
-			atomic.AddInt64(&q.running, 1)
-			go func(t Task) {
-				t.Run(t.When())
-				q.wg.Done()
-				atomic.AddInt64(&q.running, -1)
-			}(q.queue[i])
-
-			q.queue[i] = nil
-			front = i + 1
-		} else {
+	now := q.clock.Now()
+	var due []item
+	for q.queue.Len() > 0 && !q.queue.peek().task.When().After(now) {
+		due = append(due, q.queue.pop())
+	}
+
+	// add the next occurrence of recurring tasks before they run (or are
+	// skipped) so the occurrence is saved even if the task never finishes
+	for _, it := range due {
+		if r, ok := it.task.(Recurring); ok {
+			if next := r.Recur(now); next != nil {
+				q.lastID++
+				q.queue.push(item{id: q.lastID, task: next})
+			}
+		}
+	}
+
+	run, skips := q.applyMissed(due, now)
+	for _, it := range run {
+		if q.key != nil {
+			it.key = q.key(it.task)
+		}
+		q.waiting = append(q.waiting, it)
+	}
+
+	q.dispatch()
+	q.m.Unlock()
+
+	if q.skipped != nil {
+		for _, s := range skips {
+			q.skipped(s.entry, s.reason)
+		}
+	}
+}
+
+// dispatch runs waiting tasks, in order, until the worker limit is reached.
+// Tasks whose key is at its limit are skipped and continue to wait.
+// q.m must be held by the caller.
+func (q *TaskQueue) dispatch() {
+	if q.stopped {
+		return
+	}
+
+	remaining := q.waiting[:0]
+	for i, it := range q.waiting {
+		if q.workers > 0 && len(q.running) >= q.workers {
+			remaining = append(remaining, q.waiting[i:]...)
 			break
 		}
+		if q.perKey > 0 && it.key != "" && q.keyRunning[it.key] >= q.perKey {
+			remaining = append(remaining, it)
+			continue
+		}
+
+		q.keyRunning[it.key]++
+		q.running[it.id] = it
+		q.wg.Add(1)
+		go func(it item) {
+			start := q.clock.Now()
+			err := tryRun(it.task, it.task.When())
+			q.finished(it, err, start)
+		}(it)
 	}
 
-	// move all remaining tasks forward
-	keep := q.queue[front:]
-	for i := range keep {
-		q.queue[i] = keep[i]
+	// clear references to dispatched tasks beyond the new length
+	for i := len(remaining); i < len(q.waiting); i++ {
+		q.waiting[i] = item{}
 	}
+	q.waiting = remaining
+}
 
-	// slice off the 'extra'
-	q.queue = q.queue[:len(keep)]
+// finished records that the task it, started at start, is done, schedules
+// a retry if it failed, and runs any tasks that were waiting for it.
+func (q *TaskQueue) finished(it item, err error, start time.Time) {
+	defer q.wg.Done()
+
+	q.m.Lock()
+	now := q.clock.Now()
+	q.keyRunning[it.key]--
+	if q.keyRunning[it.key] <= 0 {
+		delete(q.keyRunning, it.key)
+	}
+	delete(q.running, it.id)
+
+	result := q.scheduleRetry(it, err, now)
+	result.Took = now.Sub(start)
+
+	q.dispatch()
+	q.m.Unlock()
+
+	if !result.Retry.IsZero() && q.changed != nil {
+		q.changed()
+	}
+	if q.results != nil {
+		q.results(result)
+	}
+}
+
+// Stop prevents any more waiting tasks from being run. Tasks already
+// running are unaffected.
+func (q *TaskQueue) Stop() {
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.stopped = true
+}
+
+// Remove removes the task with id from the queue if it hasn't started
+// running. It reports if the task was removed.
+func (q *TaskQueue) Remove(id ID) bool {
+	return q.RemoveWhere(func(e Entry) bool { return e.ID == id }) > 0
+}
+
+// RemoveWhere removes all tasks which haven't started running and for
+// which remove returns true. It returns the number of tasks removed.
+func (q *TaskQueue) RemoveWhere(remove func(Entry) bool) int {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	before := len(q.waiting) + len(q.queue)
+
+	waiting := q.waiting[:0]
+	for _, it := range q.waiting {
+		if !remove(newEntry(it.id, it.task, Waiting)) {
+			waiting = append(waiting, it)
+		}
+	}
+	for i := len(waiting); i < len(q.waiting); i++ {
+		q.waiting[i] = item{} // clear references to removed tasks
+	}
+	q.waiting = waiting
+
+	q.queue.filter(func(it item) bool {
+		return remove(newEntry(it.id, it.task, Queued))
+	})
+
+	return before - len(q.waiting) - len(q.queue)
+}
+
+// List describes every task which is running, waiting, or queued.
+// Running tasks are listed first, followed by the others in the order
+// they will be run.
+func (q *TaskQueue) List() []Entry {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	entries := make([]Entry, 0, len(q.running)+len(q.waiting)+len(q.queue))
+	for _, it := range q.running {
+		entries = append(entries, newEntry(it.id, it.task, Running))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	for _, it := range q.waiting {
+		entries = append(entries, newEntry(it.id, it.task, Waiting))
+	}
+	for _, it := range q.queue.sorted() {
+		entries = append(entries, newEntry(it.id, it.task, Queued))
+	}
+	return entries
 }
 
 // Next gets the time the next Task must be processed.
 func (q *TaskQueue) Next() time.Time {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if len(q.queue) > 0 {
+		return q.queue.peek().task.When()
+	}
+	return q.clock.Now()
+}
 
-	if q.Len() > 0 {
-		q.m.Lock()
-		defer q.m.Unlock()
-		return q.queue[0].When()
+// Tasks returns a copy of the Tasks currently waiting or in the queue, in
+// the order they will be processed.
+func (q *TaskQueue) Tasks() []Task {
+	q.m.Lock()
+	defer q.m.Unlock()
+	tasks := make([]Task, 0, len(q.waiting)+len(q.queue))
+	for _, it := range q.waiting {
+		tasks = append(tasks, it.task)
+	}
+	for _, it := range q.queue.sorted() {
+		tasks = append(tasks, it.task)
 	}
-	return time.Now()
+	return tasks
 }
 
 // Len returns the number of Tasks currently in the queue.
@@ -119,11 +374,19 @@ func (q *TaskQueue) Len() int {
 	return len(q.queue)
 }
 
+// Waiting returns the number of Tasks which are due but waiting
+// for a worker.
+func (q *TaskQueue) Waiting() int {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return len(q.waiting)
+}
+
 // Running returns the number of Tasks currently running.
 func (q *TaskQueue) Running() int {
 	q.m.Lock()
 	defer q.m.Unlock()
-	return int(q.running)
+	return len(q.running)
 }
 
 // Wg gives access to a WaitGroup for the running tasks.
@@ -132,5 +395,6 @@ func (q *TaskQueue) Wg() *sync.WaitGroup {
 }
 
 func (q *TaskQueue) String() string {
-	return fmt.Sprintf("%d in queue| %d running| next task at %s", q.Len(), q.Running(), q.Next())
+	return fmt.Sprintf("%d in queue| %d waiting| %d running| next task at %s",
+		q.Len(), q.Waiting(), q.Running(), q.Next())
 }
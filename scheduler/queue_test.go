@@ -0,0 +1,143 @@
+package scheduler
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestQueueOrder(t *testing.T) {
+	q := NewTaskQueue()
+	base := time.Now().Add(time.Hour)
+	r := rand.New(rand.NewSource(1))
+
+	// several tasks at each of a few times, added in random order
+	var ids []ID
+	for i := 0; i < 200; i++ {
+		ids = append(ids, q.Append(NewTask(base.Add(time.Duration(r.Intn(5))*time.Minute), nil)))
+	}
+
+	entries := q.List()
+	if len(entries) != len(ids) {
+		t.Fatalf("listed %d entries, want %d", len(entries), len(ids))
+	}
+	for i := 1; i < len(entries); i++ {
+		prev, cur := entries[i-1], entries[i]
+		if cur.When.Before(prev.When) {
+			t.Fatalf("entry %d (%s) before entry %d (%s)", i, cur.When, i-1, prev.When)
+		}
+		if cur.When.Equal(prev.When) && cur.ID < prev.ID {
+			t.Fatalf("tasks at %s not first-in first-out: %d before %d", cur.When, prev.ID, cur.ID)
+		}
+	}
+	if !q.Next().Equal(entries[0].When) {
+		t.Errorf("Next() = %s, want %s", q.Next(), entries[0].When)
+	}
+}
+
+// linearQueue is the TaskQueue insertion used before the heap: a linear
+// scan for the insertion index and a shift of the rest of the slice. It's
+// kept for comparison in benchmarks.
+type linearQueue struct {
+	queue []Task
+}
+
+func (q *linearQueue) Append(t Task) {
+	var i int
+	for ; i < len(q.queue) && q.queue[i].When().Before(t.When()); i++ {
+	}
+
+	q.queue = append(q.queue, nil)
+	copy(q.queue[i+1:], q.queue[i:])
+	q.queue[i] = t
+}
+
+// pop removes the earliest task.
+func (q *linearQueue) pop() Task {
+	t := q.queue[0]
+	copy(q.queue, q.queue[1:])
+	q.queue = q.queue[:len(q.queue)-1]
+	return t
+}
+
+// benchTasks creates n tasks at 5 minute intervals, shuffled, similar to a
+// day's worth of scrapes from several cameras being scheduled.
+func benchTasks(n int) []Task {
+	base := time.Now()
+	tasks := make([]Task, n)
+	for i := range tasks {
+		tasks[i] = NewTask(base.Add(time.Duration(i%288)*5*time.Minute), nil)
+	}
+	rand.New(rand.NewSource(1)).Shuffle(n, func(i, j int) { tasks[i], tasks[j] = tasks[j], tasks[i] })
+	return tasks
+}
+
+var benchSizes = []struct {
+	name string
+	n    int
+}{
+	{"1k", 1000},
+	{"15k", 50 * 288}, // ~50 cameras at 5 minute intervals
+}
+
+func BenchmarkAppendHeap(b *testing.B) {
+	for _, size := range benchSizes {
+		tasks := benchTasks(size.n)
+		b.Run(size.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				q := NewTaskQueue()
+				for _, t := range tasks {
+					q.Append(t)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAppendLinear(b *testing.B) {
+	for _, size := range benchSizes {
+		tasks := benchTasks(size.n)
+		b.Run(size.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				q := &linearQueue{}
+				for _, t := range tasks {
+					q.Append(t)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDrainHeap(b *testing.B) {
+	for _, size := range benchSizes {
+		tasks := benchTasks(size.n)
+		b.Run(size.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				var h taskHeap
+				for j, t := range tasks {
+					h.push(item{id: ID(j), task: t})
+				}
+				for h.Len() > 0 {
+					h.pop()
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDrainLinear(b *testing.B) {
+	for _, size := range benchSizes {
+		tasks := benchTasks(size.n)
+		b.Run(size.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				q := &linearQueue{}
+				for _, t := range tasks {
+					q.Append(t)
+				}
+				for len(q.queue) > 0 {
+					q.pop()
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+)
+
+// Schedule determines when a recurring Task runs.
+type Schedule interface {
+	// Next returns the first time after t that the task runs, or the
+	// zero time if it never runs again.
+	Next(t time.Time) time.Time
+}
+
+// Every is a Schedule which runs a task at a fixed interval.
+func Every(d time.Duration) Schedule {
+	return every(d)
+}
+
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	if e <= 0 {
+		return time.Time{}
+	}
+	return t.Add(time.Duration(e))
+}
+
+// DailyAt is a Schedule which runs a task once a day at hour:minute in loc.
+// On days when that time doesn't exist (eg 2:30 when daylight saving time
+// starts) the task runs at the equivalent time after the transition.
+func DailyAt(hour, minute int, loc *time.Location) Schedule {
+	return daily{hour: hour, minute: minute, loc: loc}
+}
+
+// Midnight is a Schedule which runs a task at the start of each day in loc.
+func Midnight(loc *time.Location) Schedule {
+	return DailyAt(0, 0, loc)
+}
+
+type daily struct {
+	hour, minute int
+	loc          *time.Location
+}
+
+func (d daily) Next(t time.Time) time.Time {
+	t = t.In(d.loc)
+	next := d.on(t.Year(), t.Month(), t.Day())
+	for day := 1; !next.After(t); day++ {
+		next = d.on(t.Year(), t.Month(), t.Day()+day)
+	}
+	return next
+}
+
+// on returns hour:minute on the given day. time.Date() normalizes invalid
+// days (eg "Oct 32"), but may move a time skipped by daylight saving time
+// backwards, so such times are moved forward past the transition.
+func (d daily) on(year int, month time.Month, day int) time.Time {
+	t := time.Date(year, month, day, d.hour, d.minute, 0, 0, d.loc)
+	want := d.hour*60 + d.minute
+	got := t.Hour()*60 + t.Minute()
+	if got != want {
+		diff := want - got
+		if diff < 0 {
+			diff += 24 * 60
+		}
+		t = t.Add(time.Duration(diff) * time.Minute)
+	}
+	return t
+}
+
+func (d daily) String() string {
+	return fmt.Sprintf("daily at %02d:%02d %s", d.hour, d.minute, d.loc)
+}
+
+// Recurring is implemented by Tasks which run repeatedly. When a Recurring
+// task is due, the Scheduler adds the task returned by Recur in its place,
+// whether or not the due task is run or skipped.
+type Recurring interface {
+	// Recur returns the next occurrence of the task, due after now, or
+	// nil if the task doesn't occur again.
+	Recur(now time.Time) Task
+}
+
+// Repeat makes a task created by NewTask, NewTaggedTask, or NewPersistentTask
+// recur on schedule after it first runs at its When() time, and returns it.
+// Other Tasks are returned unchanged; they can implement Recurring instead.
+func Repeat(t Task, schedule Schedule) Task {
+	switch t := t.(type) {
+	case *task:
+		t.schedule = schedule
+	case *persistentTask:
+		t.schedule = schedule
+	}
+	return t
+}
+
+// NewRecurringTask creates a task with tags which calls run at each time
+// given by schedule after now.
+func NewRecurringTask(now time.Time, schedule Schedule, tags Tags, run func(time.Time)) Task {
+	return Repeat(NewTaggedTask(schedule.Next(now), tags, run), schedule)
+}
+
+func (t *task) Recur(now time.Time) Task {
+	if t.schedule == nil {
+		return nil
+	}
+	next := *t
+	next.when = nextAfter(t.schedule, t.when, now)
+	if next.when.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+func (t *persistentTask) Recur(now time.Time) Task {
+	if t.schedule == nil {
+		return nil
+	}
+	next := *t
+	next.when = nextAfter(t.schedule, t.when, now)
+	if next.when.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+// nextAfter returns the first time in schedule after both when and now, so
+// that occurrences missed while the scheduler wasn't running are not all
+// added at once.
+func nextAfter(schedule Schedule, when, now time.Time) time.Time {
+	// skip ahead to just before now rather than stepping through each
+	// missed interval
+	if e, ok := schedule.(every); ok && e > 0 && when.Before(now) {
+		missed := now.Sub(when) / time.Duration(e)
+		when = when.Add(missed * time.Duration(e))
+	}
+
+	next := schedule.Next(when)
+	for !next.IsZero() && !next.After(now) {
+		next = schedule.Next(next)
+	}
+	return next
+}
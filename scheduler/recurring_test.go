@@ -0,0 +1,117 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCron(t *testing.T) {
+	pacific, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip(err)
+	}
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, pacific)
+	}
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want []time.Time
+	}{
+		{"*/15 * * * *", at(6, 1, 10, 7), []time.Time{at(6, 1, 10, 15), at(6, 1, 10, 30), at(6, 1, 10, 45)}},
+		{"0 3 * * *", at(6, 1, 3, 0), []time.Time{at(6, 2, 3, 0), at(6, 3, 3, 0)}},
+		{"30 8-9 * * 1-5", at(6, 5, 9, 0), []time.Time{at(6, 5, 9, 30), at(6, 8, 8, 30)}}, // Friday to Monday
+		{"0 0 1,15 * *", at(6, 2, 0, 0), []time.Time{at(6, 15, 0, 0), at(7, 1, 0, 0)}},
+		{"0 12 13 * 5", at(2, 1, 0, 0), []time.Time{at(2, 6, 12, 0), at(2, 13, 12, 0), at(2, 20, 12, 0)}}, // the 13th or a Friday
+		{"0 0 * * 7", at(6, 1, 0, 0), []time.Time{at(6, 7, 0, 0)}},                                         // 7 is Sunday
+		// 2:30 doesn't exist when daylight saving time starts
+		{"30 2 * * *", at(3, 7, 12, 0), []time.Time{at(3, 9, 2, 30)}},
+		// 1:30 happens twice when daylight saving time ends, but runs once
+		{"30 1 * * *", at(11, 1, 0, 0), []time.Time{at(11, 1, 1, 30), at(11, 2, 1, 30)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			sched, err := Cron(tt.expr, pacific)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := tt.from
+			for _, want := range tt.want {
+				next = sched.Next(next)
+				if !next.Equal(want) {
+					t.Fatalf("Next() = %s, want %s", next, want)
+				}
+			}
+		})
+	}
+
+	for _, bad := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := Cron(bad, pacific); err == nil {
+			t.Errorf("Cron(%q) expected error", bad)
+		}
+	}
+
+	never, _ := Cron("0 0 31 2 *", pacific)
+	if next := never.Next(at(1, 1, 0, 0)); !next.IsZero() {
+		t.Errorf("Feb 31 matched at %s", next)
+	}
+}
+
+func TestDailyAt(t *testing.T) {
+	pacific, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// midnight each day across both daylight saving time transitions
+	for _, start := range []time.Time{
+		time.Date(2026, 3, 6, 12, 0, 0, 0, pacific),
+		time.Date(2026, 10, 30, 12, 0, 0, 0, pacific),
+	} {
+		next := start
+		for i := 1; i <= 4; i++ {
+			next = Midnight(pacific).Next(next)
+			want := time.Date(start.Year(), start.Month(), start.Day()+i, 0, 0, 0, 0, pacific)
+			if !next.Equal(want) {
+				t.Errorf("Next() = %s, want %s", next, want)
+			}
+		}
+	}
+
+	// 2:30 doesn't exist on Mar 8, so it runs at 3:30 instead
+	next := DailyAt(2, 30, pacific).Next(time.Date(2026, 3, 7, 12, 0, 0, 0, pacific))
+	if want := time.Date(2026, 3, 8, 3, 30, 0, 0, pacific); !next.Equal(want) {
+		t.Errorf("Next() = %s, want %s", next, want)
+	}
+}
+
+func TestRecurringTask(t *testing.T) {
+	start := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	s := NewScheduler(WithClock(clock))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+
+	ran := make(chan time.Time, 10)
+	s.Add(NewRecurringTask(start, Every(10*time.Minute), nil, func(when time.Time) { ran <- when }))
+
+	for i := 1; i <= 3; i++ {
+		clock.Advance(10 * time.Minute)
+		when := waitFor(t, ran, 1)[0]
+		if want := start.Add(time.Duration(i) * 10 * time.Minute); !when.Equal(want) {
+			t.Errorf("run %d at %s, want %s", i, when, want)
+		}
+	}
+
+	// after a long gap only the next occurrence is queued, not all the
+	// missed ones
+	clock.Advance(5 * time.Hour)
+	waitFor(t, ran, 1)
+	entries := s.List()
+	if len(entries) != 1 || !entries[0].When.After(clock.Now()) {
+		t.Errorf("expected one future occurrence, got %+v", entries)
+	}
+}
@@ -0,0 +1,224 @@
+package scheduler
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Fallible is implemented by Tasks which report if they failed. The
+// Scheduler calls TryRun instead of Run, and may retry a task which returns
+// an error according to the task's RetryPolicy.
+type Fallible interface {
+	TryRun(time.Time) error
+}
+
+// RetryPolicy decides if and when a failed Fallible task is retried. The
+// zero value never retries.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times the task is run,
+	// including the first. 0 or 1 never retries.
+	MaxAttempts int
+	// Backoff is the time to wait before the first retry.
+	Backoff time.Duration
+	// Multiplier increases the wait before each subsequent retry.
+	// Values less than 1 (including 0) are treated as 1, a constant wait.
+	Multiplier float64
+	// MaxBackoff limits the wait between retries. 0 is unlimited.
+	MaxBackoff time.Duration
+	// Jitter randomly varies each wait by up to this fraction (0-1) of
+	// the wait, so retries of many tasks which failed at once are spread out.
+	Jitter float64
+	// Until, if not 0, stops retrying once the retry would be later than
+	// Until after the time the task was originally due.
+	Until time.Duration
+}
+
+// ExponentialBackoff is a RetryPolicy making up to attempts attempts,
+// waiting initially backoff between attempts and doubling it each time,
+// varied by 10%.
+func ExponentialBackoff(attempts int, backoff time.Duration) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: attempts,
+		Backoff:     backoff,
+		Multiplier:  2,
+		Jitter:      0.1}
+}
+
+// next returns when to retry a task which has failed attempts times and was
+// originally due at first. ok is false if the task shouldn't be retried.
+func (p RetryPolicy) next(attempts int, first, now time.Time) (at time.Time, ok bool) {
+	if attempts >= p.MaxAttempts {
+		return time.Time{}, false
+	}
+
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+	wait := float64(p.Backoff) * math.Pow(mult, float64(attempts-1))
+	if p.MaxBackoff > 0 && wait > float64(p.MaxBackoff) {
+		wait = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		wait += wait * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	at = now.Add(time.Duration(wait))
+	if p.Until > 0 && at.After(first.Add(p.Until)) {
+		return time.Time{}, false
+	}
+	return at, true
+}
+
+// Retryable is implemented by Tasks which have their own RetryPolicy,
+// overriding the Scheduler's. ok is false if the task uses the Scheduler's.
+type Retryable interface {
+	RetryPolicy() (p RetryPolicy, ok bool)
+}
+
+// WithRetryPolicy sets the RetryPolicy of a task created by NewFallibleTask
+// or NewPersistentTask and returns it. Other Tasks are returned unchanged;
+// they can implement Retryable instead.
+func WithRetryPolicy(t Task, p RetryPolicy) Task {
+	switch t := t.(type) {
+	case *task:
+		t.retry = &p
+	case *persistentTask:
+		t.retry = &p
+	}
+	return t
+}
+
+func (t *task) RetryPolicy() (RetryPolicy, bool) {
+	if t.retry == nil {
+		return RetryPolicy{}, false
+	}
+	return *t.retry, true
+}
+
+// RetryFailed configures the RetryPolicy used for failed Fallible tasks
+// which don't have their own. The default never retries.
+func RetryFailed(p RetryPolicy) Option {
+	return func(s *Scheduler) {
+		s.queue.retry = p
+	}
+}
+
+// Result is the outcome of running a Task.
+type Result struct {
+	Entry   Entry
+	Attempt int           // 1 for the first attempt
+	Err     error         // nil if the task succeeded or isn't Fallible
+	Took    time.Duration // time the task took to run
+	Retry   time.Time     // time of the next attempt. zero if not retried
+}
+
+// ReportResults configures a Scheduler to call report with the Result of
+// each Task it runs.
+func ReportResults(report func(Result)) Option {
+	return func(s *Scheduler) {
+		s.queue.results = report
+	}
+}
+
+// permanent is an error which shouldn't be retried.
+type permanent struct {
+	err error
+}
+
+func (p permanent) Error() string { return p.err.Error() }
+
+func (p permanent) Unwrap() error { return p.err }
+
+// Permanent marks err as a failure which retrying won't fix, so the task
+// returning it isn't retried regardless of its RetryPolicy.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanent{err: err}
+}
+
+// IsPermanent reports if the cause of err (see errors.Cause) was marked
+// with Permanent.
+func IsPermanent(err error) bool {
+	_, ok := errors.Cause(err).(permanent)
+	return ok
+}
+
+// retryTask is another attempt at a failed Task.
+type retryTask struct {
+	Task              // the original task
+	when    time.Time // time of this attempt
+	attempt int       // attempt number, starting at 1 for the original
+	first   time.Time // time the original task was due
+}
+
+func (r *retryTask) When() time.Time { return r.when }
+
+func (r *retryTask) TryRun(when time.Time) error { return tryRun(r.Task, when) }
+
+func (r *retryTask) Tags() Tags {
+	if tagged, ok := r.Task.(Tagged); ok {
+		return tagged.Tags()
+	}
+	return nil
+}
+
+// tryRun runs t at when, returning its error if it's Fallible.
+func tryRun(t Task, when time.Time) error {
+	if f, ok := t.(Fallible); ok {
+		return f.TryRun(when)
+	}
+	t.Run(when)
+	return nil
+}
+
+// retryPolicy gets the RetryPolicy for t, which may be a retry.
+func (q *TaskQueue) retryPolicy(t Task) RetryPolicy {
+	if r, ok := t.(*retryTask); ok {
+		t = r.Task
+	}
+	if r, ok := t.(Retryable); ok {
+		if p, ok := r.RetryPolicy(); ok {
+			return p
+		}
+	}
+	return q.retry
+}
+
+// scheduleRetry adds another attempt of it, which failed at now, if its
+// RetryPolicy allows. It returns the Result of the failed attempt.
+// q.m must be held by the caller.
+func (q *TaskQueue) scheduleRetry(it item, err error, now time.Time) Result {
+	attempt, first, original := 1, it.task.When(), it.task
+	if r, ok := it.task.(*retryTask); ok {
+		attempt, first, original = r.attempt, r.first, r.Task
+	}
+	result := Result{
+		Entry:   newEntry(it.id, it.task, Running),
+		Attempt: attempt,
+		Err:     err}
+
+	if err == nil || IsPermanent(err) || q.stopped {
+		return result
+	}
+
+	at, ok := q.retryPolicy(original).next(attempt, first, now)
+	if !ok {
+		return result
+	}
+	q.lastID++
+	q.queue.push(item{
+		id: q.lastID,
+		task: &retryTask{
+			Task:    original,
+			when:    at,
+			attempt: attempt + 1,
+			first:   first}})
+	result.Retry = at
+	return result
+}
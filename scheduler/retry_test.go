@@ -0,0 +1,166 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestRetryPolicyNext(t *testing.T) {
+	first := time.Date(2026, 6, 1, 6, 0, 0, 0, time.UTC)
+	p := RetryPolicy{
+		MaxAttempts: 5,
+		Backoff:     30 * time.Second,
+		Multiplier:  2,
+		MaxBackoff:  time.Minute,
+		Until:       3 * time.Minute}
+
+	tests := []struct {
+		attempts int
+		now      time.Time
+		want     time.Time // zero if not retried
+	}{
+		{1, first, first.Add(30 * time.Second)},
+		{2, first.Add(30 * time.Second), first.Add(90 * time.Second)},
+		{3, first.Add(90 * time.Second), first.Add(150 * time.Second)}, // limited by MaxBackoff
+		{4, first.Add(150 * time.Second), time.Time{}},                 // past Until
+		{5, first, time.Time{}},                                        // MaxAttempts
+	}
+	for _, tt := range tests {
+		got, ok := p.next(tt.attempts, first, tt.now)
+		if ok != !tt.want.IsZero() || !got.Equal(tt.want) {
+			t.Errorf("after %d attempts got %s (%t), want %s", tt.attempts, got, ok, tt.want)
+		}
+	}
+
+	if _, ok := (RetryPolicy{}).next(1, first, first); ok {
+		t.Error("zero RetryPolicy retried")
+	}
+
+	p = RetryPolicy{MaxAttempts: 100, Backoff: time.Minute, Jitter: 0.5}
+	for i := 1; i < 100; i++ {
+		got, _ := p.next(i, first, first)
+		if wait := got.Sub(first); wait < 30*time.Second || wait > 90*time.Second {
+			t.Fatalf("jittered wait %s outside 30s-90s", wait)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	start := time.Date(2026, 6, 1, 6, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	results := make(chan Result, 10)
+	s := NewScheduler(
+		WithClock(clock),
+		RetryFailed(RetryPolicy{MaxAttempts: 3, Backoff: 30 * time.Second, Multiplier: 2}),
+		ReportResults(func(r Result) { results <- r }))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+
+	next := func() Result {
+		t.Helper()
+		select {
+		case r := <-results:
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatal("no result")
+		}
+		return Result{}
+	}
+
+	// fails twice, then succeeds
+	var tries []time.Time
+	s.Add(NewFallibleTask(start, Tags{"name": "flaky"}, func(when time.Time) error {
+		tries = append(tries, when)
+		if len(tries) < 3 {
+			return errors.New("down")
+		}
+		return nil
+	}))
+	clock.Advance(0)
+	for i, wait := range []time.Duration{30 * time.Second, time.Minute} {
+		r := next()
+		if r.Attempt != i+1 || r.Err == nil || !r.Retry.Equal(clock.Now().Add(wait)) {
+			t.Fatalf("attempt %d: got %+v, want retry in %s", i+1, r, wait)
+		}
+		if r.Entry.Tags["name"] != "flaky" {
+			t.Errorf("retry lost its tags: %v", r.Entry.Tags)
+		}
+		clock.Advance(wait)
+	}
+	if r := next(); r.Attempt != 3 || r.Err != nil || !r.Retry.IsZero() {
+		t.Fatalf("last attempt: got %+v, want success", r)
+	}
+	want := []time.Time{start, start.Add(30 * time.Second), start.Add(90 * time.Second)}
+	for i := range want {
+		if !tries[i].Equal(want[i]) {
+			t.Errorf("try %d at %s, want %s", i+1, tries[i], want[i])
+		}
+	}
+
+	// permanent errors and infallible tasks aren't retried
+	s.Add(NewFallibleTask(clock.Now(), nil, func(time.Time) error {
+		return errors.Wrap(Permanent(errors.New("gone")), "fetching")
+	}))
+	clock.Advance(0)
+	if r := next(); r.Err == nil || !IsPermanent(r.Err) || !r.Retry.IsZero() {
+		t.Errorf("permanent failure: got %+v, want no retry", r)
+	}
+	s.Add(NewTask(clock.Now(), func(time.Time) {}))
+	clock.Advance(0)
+	if r := next(); r.Err != nil || r.Attempt != 1 {
+		t.Errorf("infallible task: got %+v", r)
+	}
+	if n := s.queue.Len(); n != 0 {
+		t.Errorf("%d tasks left in queue, want 0", n)
+	}
+}
+
+func TestSaveRestoreRetry(t *testing.T) {
+	type args struct{ N int }
+	factories := map[string]Factory{
+		"num": func(rec Record) (Task, error) {
+			var a args
+			if err := json.Unmarshal(rec.Args, &a); err != nil {
+				return nil, err
+			}
+			t := NewPersistentTask(rec.When, "num", a, nil, func(time.Time) error { return nil })
+			return Repeat(t, Every(time.Hour)), nil
+		},
+	}
+
+	when := time.Date(2026, 6, 1, 6, 0, 0, 0, time.UTC)
+	store := &memStore{}
+	first := NewScheduler(PersistTo(store, time.Minute, factories))
+	first.queue.Append(&retryTask{
+		Task:    NewPersistentTask(when, "num", args{N: 1}, nil, nil),
+		when:    when.Add(time.Minute),
+		attempt: 2,
+		first:   when})
+	if err := first.Save(); err != nil {
+		t.Fatal(err)
+	}
+	rec := store.recs[0]
+	if rec.Attempt != 2 || !rec.First.Equal(when) || !rec.When.Equal(when.Add(time.Minute)) {
+		t.Fatalf("saved %+v", rec)
+	}
+
+	second := NewScheduler(PersistTo(store, time.Minute, factories))
+	if _, err := second.Restore(); err != nil {
+		t.Fatal(err)
+	}
+	r, ok := second.queue.Tasks()[0].(*retryTask)
+	if !ok {
+		t.Fatalf("restored %T, want a retry", second.queue.Tasks()[0])
+	}
+	if r.attempt != 2 || !r.first.Equal(when) || !r.When().Equal(when.Add(time.Minute)) {
+		t.Errorf("restored retry %+v", r)
+	}
+	if _, ok := Task(r).(Recurring); ok {
+		t.Error("restored retry of a recurring task recurs")
+	}
+}
@@ -28,17 +28,44 @@ func StopWhenQueueEmpty() Option {
 	}
 }
 
+// Workers configures a Scheduler to run at most n Tasks at once. Tasks which
+// are due while n Tasks are running wait until one finishes.
+func Workers(n int) Option {
+	return func(s *Scheduler) {
+		s.queue.workers = n
+	}
+}
+
+// LimitPerKey configures a Scheduler to run at most n Tasks with the same
+// key at once. key is called once for each Task when it is due. Tasks with
+// an empty key are not limited.
+func LimitPerKey(n int, key func(Task) string) Option {
+	return func(s *Scheduler) {
+		s.queue.perKey = n
+		s.queue.key = key
+	}
+}
+
 // Scheduler keeps a queue of tasks and processes them at their scheduled time.
 type Scheduler struct {
 
 	// primary processing mechanisms
 	queue *TaskQueue
-	timer *time.Timer
+	timer Timer
+	clock Clock
 
 	// config options
 	stopOnEmptyQueue bool
 	waitTimeout      time.Duration
 
+	// persistence
+	store        Store
+	factories    map[string]Factory
+	persistEvery time.Duration
+	dirty        bool
+	persistMutex sync.Mutex // guards dirty
+	saveMutex    sync.Mutex // serializes Save
+
 	// concurrency control
 	done  chan struct{}
 	mutex sync.Mutex
@@ -48,13 +75,22 @@ type Scheduler struct {
 func NewScheduler(options ...Option) *Scheduler {
 	s := &Scheduler{
 		queue: NewTaskQueue(),
-		timer: time.NewTimer(-1)}
+		clock: SystemClock}
 
 	// apply options
 	for _, opt := range options {
 		opt(s)
 	}
 
+	// created after options since the clock may have changed
+	s.timer = s.clock.NewTimer(-1)
+
+	// retries are added to the queue when a task finishes
+	s.queue.changed = func() {
+		s.markDirty()
+		s.resetTimer(s.queue.Next())
+	}
+
 	return s
 }
 
@@ -62,13 +98,33 @@ func NewScheduler(options ...Option) *Scheduler {
 func (s *Scheduler) Start(ctx context.Context) {
 	s.done = make(chan struct{})
 
+	// a nil channel blocks forever, so without a store the
+	// persist case below is never selected
+	var persist <-chan time.Time
+	var persistTimer Timer
+	if s.store != nil && s.persistEvery > 0 {
+		persistTimer = s.clock.NewTimer(s.persistEvery)
+		persist = persistTimer.C()
+	}
+
 	go func() {
+		if persistTimer != nil {
+			defer persistTimer.Stop()
+		}
+
 		for {
 			select {
 
-			case <-s.timer.C:
+			case <-persist:
+				if s.isDirty() {
+					s.Save() // on failure, try again next time
+				}
+				persistTimer.Reset(s.persistEvery)
+
+			case <-s.timer.C():
 				if s.queue.Len() > 0 {
 					s.queue.Process()
+					s.markDirty()
 					s.resetTimer(s.queue.Next())
 				} else if s.stopOnEmptyQueue {
 					// the queue is empty and the scheduler is configured to
@@ -80,7 +136,8 @@ func (s *Scheduler) Start(ctx context.Context) {
 			case <-ctx.Done():
 				// the context was canceled or whatever.
 				// this will wait up to waitTimeout for the tasks currently
-				// running finish.
+				// running finish. tasks waiting for a worker are not run.
+				s.queue.Stop()
 				tasksDone := make(chan struct{})
 				go func() {
 					defer close(tasksDone)
@@ -88,10 +145,14 @@ func (s *Scheduler) Start(ctx context.Context) {
 				}()
 
 				select {
-				case <-time.After(s.waitTimeout):
+				case <-s.clock.After(s.waitTimeout):
 				case <-tasksDone:
 				}
 
+				// save tasks remaining in the queue (including any
+				// added by the tasks that just finished)
+				s.Save()
+
 				close(s.done)
 				return
 			}
@@ -105,12 +166,12 @@ func (s *Scheduler) resetTimer(t time.Time) {
 	defer s.mutex.Unlock()
 	if !s.timer.Stop() { // stupid bullshit
 		select {
-		case <-s.timer.C:
+		case <-s.timer.C():
 			// draining timer channel per docs.
 		default:
 		}
 	}
-	s.timer.Reset(time.Until(t))
+	s.timer.Reset(t.Sub(s.clock.Now()))
 }
 
 // Wait blocks the current goroutine until the context passed to Start()
@@ -119,10 +180,35 @@ func (s *Scheduler) Wait() {
 	<-s.done
 }
 
-// Add enqueues a task.
-func (s *Scheduler) Add(t Task) {
-	s.queue.Append(t)
+// Add enqueues a task, returning the ID assigned to it.
+func (s *Scheduler) Add(t Task) ID {
+	id := s.queue.Append(t)
+	s.markDirty()
 	s.resetTimer(s.queue.Next())
+	return id
+}
+
+// Remove dequeues the task with id if it hasn't started running. It reports
+// if the task was removed.
+func (s *Scheduler) Remove(id ID) bool {
+	return s.RemoveWhere(func(e Entry) bool { return e.ID == id }) > 0
+}
+
+// RemoveWhere dequeues all tasks which haven't started running and for
+// which remove returns true. It returns the number of tasks removed.
+func (s *Scheduler) RemoveWhere(remove func(Entry) bool) int {
+	n := s.queue.RemoveWhere(remove)
+	if n > 0 {
+		s.markDirty()
+		s.resetTimer(s.queue.Next())
+	}
+	return n
+}
+
+// List describes every task which is running, waiting for a worker, or
+// queued.
+func (s *Scheduler) List() []Entry {
+	return s.queue.List()
 }
 
 // Running returns the number of Tasks currently running.
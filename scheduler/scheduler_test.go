@@ -2,7 +2,10 @@ package scheduler
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 )
@@ -42,3 +45,383 @@ func nowplus(sec int) time.Time {
 	n := time.Now().Add(time.Duration(sec) * time.Second)
 	return time.Date(n.Year(), n.Month(), n.Day(), n.Hour(), n.Minute(), n.Second(), 0, n.Location())
 }
+
+// memStore is a Store which keeps records in memory.
+type memStore struct {
+	recs []Record
+}
+
+func (m *memStore) SaveTasks(recs []Record) error {
+	m.recs = recs
+	return nil
+}
+
+func (m *memStore) LoadTasks() ([]Record, error) {
+	return m.recs, nil
+}
+
+func TestSaveRestore(t *testing.T) {
+	type args struct{ N int }
+
+	ran := make(chan int, 3)
+	factories := map[string]Factory{
+		"num": func(rec Record) (Task, error) {
+			var a args
+			if err := json.Unmarshal(rec.Args, &a); err != nil {
+				return nil, err
+			}
+			return NewPersistentTask(rec.When, "num", a, nil, func(time.Time) error {
+				ran <- a.N
+				return nil
+			}), nil
+		},
+	}
+
+	store := &memStore{}
+	when := time.Now().Add(time.Hour)
+	first := NewScheduler(PersistTo(store, time.Minute, factories))
+	first.Add(NewPersistentTask(when, "num", args{N: 1}, nil, nil))
+	first.Add(NewPersistentTask(when.Add(time.Minute), "num", args{N: 2}, nil, nil))
+	first.Add(NewTask(when, nil)) // not persistent, so not saved
+	if err := first.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.recs) != 2 {
+		t.Fatalf("saved %d records, want 2", len(store.recs))
+	}
+
+	// add an unknown kind which should fail to restore
+	store.recs = append(store.recs, Record{Kind: "unknown", When: when})
+
+	second := NewScheduler(PersistTo(store, time.Minute, factories))
+	restored, err := second.Restore()
+	if err == nil {
+		t.Error("expected error restoring unknown task kind")
+	}
+	if len(restored) != 2 || second.queue.Len() != 2 {
+		t.Fatalf("restored %d records (%d in queue), want 2", len(restored), second.queue.Len())
+	}
+	if !second.queue.Next().Equal(when) {
+		t.Errorf("next task at %s, want %s", second.queue.Next(), when)
+	}
+
+	for i, task := range second.queue.Tasks() {
+		task.Run(task.When())
+		if n := <-ran; n != i+1 {
+			t.Errorf("restored task %d ran with N=%d", i+1, n)
+		}
+	}
+}
+
+// blockingStore is a Store whose SaveTasks waits for release, and then
+// returns err.
+type blockingStore struct {
+	saving  chan struct{}
+	release chan error
+}
+
+func (b *blockingStore) SaveTasks(recs []Record) error {
+	b.saving <- struct{}{}
+	return <-b.release
+}
+
+func (b *blockingStore) LoadTasks() ([]Record, error) { return nil, nil }
+
+func TestSaveUnlocked(t *testing.T) {
+	store := &blockingStore{saving: make(chan struct{}), release: make(chan error)}
+	sch := NewScheduler(PersistTo(store, time.Minute, nil))
+	when := time.Now().Add(time.Hour)
+	sch.Add(NewPersistentTask(when, "num", 1, nil, nil))
+
+	saved := make(chan error)
+	go func() { saved <- sch.Save() }()
+	<-store.saving
+
+	// adding a task doesn't wait for the store
+	added := make(chan struct{})
+	go func() {
+		sch.Add(NewPersistentTask(when, "num", 2, nil, nil))
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("Add blocked by Save")
+	}
+
+	// a failed save leaves the queue dirty
+	store.release <- errors.New("disk I/O error")
+	if err := <-saved; err == nil {
+		t.Error("failed save returned no error")
+	}
+	if !sch.isDirty() {
+		t.Error("queue not dirty after failed save")
+	}
+
+	go func() { saved <- sch.Save() }()
+	<-store.saving
+	store.release <- nil
+	if err := <-saved; err != nil {
+		t.Fatal(err)
+	}
+	if sch.isDirty() {
+		t.Error("queue dirty after successful save")
+	}
+
+	// so does a task whose args can't be marshaled
+	sch.Add(NewPersistentTask(when, "bad", make(chan int), nil, nil))
+	if err := sch.Save(); err == nil || !sch.isDirty() {
+		t.Errorf("unmarshalable args: got %v, dirty %t", err, sch.isDirty())
+	}
+}
+
+func TestConcurrencyLimits(t *testing.T) {
+	const workers, perKey = 3, 1
+
+	var m sync.Mutex
+	var running, maxRunning int
+	keyRunning := map[string]int{}
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+
+	f := func(key string) func(time.Time) {
+		return func(time.Time) {
+			m.Lock()
+			running++
+			keyRunning[key]++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			if keyRunning[key] > perKey {
+				t.Errorf("%d tasks with key %q running", keyRunning[key], key)
+			}
+			m.Unlock()
+			started <- struct{}{}
+
+			<-release
+
+			m.Lock()
+			running--
+			keyRunning[key]--
+			m.Unlock()
+		}
+	}
+
+	keys := map[Task]string{}
+	q := NewTaskQueue()
+	q.workers = workers
+	q.perKey = perKey
+	q.key = func(t Task) string { return keys[t] }
+
+	past := time.Now().Add(-time.Minute)
+	for _, key := range []string{"a", "a", "b", "b", "c", "c", ""} {
+		task := NewTask(past, f(key))
+		keys[task] = key
+		q.Append(task)
+	}
+
+	q.Process()
+	for i := 0; i < workers; i++ {
+		<-started
+	}
+	if q.Running() != workers || q.Waiting() != 4 {
+		t.Fatalf("%d running, %d waiting. want %d running, 4 waiting", q.Running(), q.Waiting(), workers)
+	}
+
+	// let everything finish
+	close(release)
+	for i := workers; i < 7; i++ {
+		<-started
+	}
+	q.Wg().Wait()
+
+	if q.Waiting() != 0 || maxRunning > workers {
+		t.Errorf("%d waiting, max running %d", q.Waiting(), maxRunning)
+	}
+}
+
+func TestRemoveAndList(t *testing.T) {
+	s := NewScheduler()
+	when := time.Now().Add(time.Hour)
+	noop := func(time.Time) {}
+
+	a := s.Add(NewTaggedTask(when, Tags{"camera": "1"}, noop))
+	b := s.Add(NewTaggedTask(when.Add(time.Minute), Tags{"camera": "2"}, noop))
+	s.Add(NewTaggedTask(when.Add(2*time.Minute), Tags{"camera": "1"}, noop))
+	s.Add(NewTask(when.Add(3*time.Minute), noop))
+
+	if !s.Remove(b) {
+		t.Errorf("task %d not removed", b)
+	}
+	if s.Remove(b) {
+		t.Errorf("task %d removed twice", b)
+	}
+
+	entries := s.List()
+	if len(entries) != 3 || entries[0].ID != a || entries[0].State != Queued {
+		t.Fatalf("unexpected entries after Remove: %+v", entries)
+	}
+
+	n := s.RemoveWhere(func(e Entry) bool { return e.Tags["camera"] == "1" })
+	if n != 2 {
+		t.Errorf("removed %d tasks with camera=1, want 2", n)
+	}
+	entries = s.List()
+	if len(entries) != 1 || entries[0].Tags != nil {
+		t.Errorf("unexpected entries after RemoveWhere: %+v", entries)
+	}
+}
+
+func TestMissedPolicy(t *testing.T) {
+	byCamera := func(t Task) string { return t.(Tagged).Tags()["camera"] }
+
+	var m sync.Mutex
+	var reasons []string
+	ran := make(chan string, 10)
+
+	q := NewTaskQueue()
+	q.missed = SkipOlderThan(time.Hour)
+	q.skipped = func(e Entry, reason string) {
+		m.Lock()
+		reasons = append(reasons, e.Tags["name"]+": "+reason)
+		m.Unlock()
+	}
+
+	now := time.Now()
+	add := func(ago time.Duration, name, camera string, p *MissedPolicy) {
+		task := NewTaggedTask(now.Add(-ago), Tags{"name": name, "camera": camera},
+			func(time.Time) { ran <- name })
+		if p != nil {
+			task = WithMissedPolicy(task, *p)
+		}
+		q.Append(task)
+	}
+
+	latest := RunLatest(byCamera)
+	all := RunAll()
+	add(3*time.Hour, "too old", "", nil)
+	add(3*time.Hour, "always", "", &all)
+	add(30*time.Minute, "cam1 early", "1", &latest)
+	add(20*time.Minute, "cam2", "2", &latest)
+	add(10*time.Minute, "cam1 late", "1", &latest)
+	add(5*time.Minute, "recent", "", nil)
+
+	q.Process()
+	q.Wg().Wait()
+	close(ran)
+
+	got := map[string]bool{}
+	for name := range ran {
+		got[name] = true
+	}
+	for _, name := range []string{"always", "cam2", "cam1 late", "recent"} {
+		if !got[name] {
+			t.Errorf("task %q didn't run", name)
+		}
+	}
+	for _, name := range []string{"too old", "cam1 early"} {
+		if got[name] {
+			t.Errorf("task %q ran but should have been skipped", name)
+		}
+	}
+	if len(reasons) != 2 {
+		t.Errorf("got %d skip reports, want 2: %v", len(reasons), reasons)
+	}
+	t.Log(reasons)
+}
+
+// waitFor receives n values from c, failing the test if they don't
+// arrive promptly.
+func waitFor(t *testing.T, c <-chan time.Time, n int) []time.Time {
+	t.Helper()
+	got := make([]time.Time, 0, n)
+	for len(got) < n {
+		select {
+		case when := <-c:
+			got = append(got, when)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d values", len(got), n)
+		}
+	}
+	return got
+}
+
+func TestFakeClockDSTDay(t *testing.T) {
+	pacific, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip(err)
+	}
+	// daylight saving time starts at 2am, so the day is 23 hours long
+	midnight := time.Date(2026, 3, 8, 0, 0, 0, 0, pacific)
+	next := time.Date(2026, 3, 9, 0, 0, 0, 0, pacific)
+
+	clock := NewFakeClock(midnight.Add(-time.Second))
+	s := NewScheduler(WithClock(clock))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+
+	ran := make(chan time.Time, 300)
+	var due []time.Time
+	for when := midnight; when.Before(next); when = when.Add(5 * time.Minute) {
+		due = append(due, when)
+		s.Add(NewTask(when, func(when time.Time) { ran <- when }))
+	}
+	if len(due) != 23*12 {
+		t.Fatalf("%d tasks in a 23 hour day, want %d", len(due), 23*12)
+	}
+
+	// step through the day a minute at a time, waiting for the tasks due
+	// at each step before moving on
+	var got []time.Time
+	for clock.Now().Before(next) {
+		clock.Advance(time.Minute)
+		var n int
+		for n < len(due) && !due[n].After(clock.Now()) {
+			n++
+		}
+		got = append(got, waitFor(t, ran, n-len(got))...)
+	}
+
+	for i := range due {
+		if !got[i].Equal(due[i]) {
+			t.Fatalf("task %d ran at %s, want %s", i, got[i], due[i])
+		}
+	}
+}
+
+func TestFakeClockDailyAcrossDST(t *testing.T) {
+	pacific, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip(err)
+	}
+	start := time.Date(2026, 11, 0, 12, 0, 0, 0, pacific) // Oct 31, noon
+
+	clock := NewFakeClock(start)
+	s := NewScheduler(WithClock(clock))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+
+	// a task which reschedules itself for the next local midnight, the
+	// way ScheduleScrapes does in scraped
+	ran := make(chan time.Time, 10)
+	var daily func(time.Time)
+	daily = func(now time.Time) {
+		ran <- now
+		now = now.In(pacific)
+		s.Add(NewTask(time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, pacific), daily))
+	}
+	s.Add(NewTask(time.Date(2026, 11, 1, 0, 0, 0, 0, pacific), daily))
+
+	// standard time starts Nov 1 at 2am, so that day is 25 hours long
+	for day := 1; day <= 3; day++ {
+		clock.Advance(25 * time.Hour)
+		when := waitFor(t, ran, 1)[0].In(pacific)
+		if when.Day() != day || when.Hour() != 0 || when.Minute() != 0 {
+			t.Errorf("daily task ran at %s, want Nov %d 00:00", when, day)
+		}
+		// back to noon so the next advance covers the next midnight
+		clock.Set(time.Date(2026, 11, day, 12, 0, 0, 0, pacific))
+	}
+}
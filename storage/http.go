@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quillaja/mtcam/log"
+)
+
+// FileServer returns a handler that serves GET and HEAD requests with the
+// blobs of s, using the request's url path (without its leading "/") as
+// the key. Use http.StripPrefix to serve s at a route other than "/".
+// If-Modified-Since is supported for every blob, and Range and If-Range
+// for blobs which can seek.
+func FileServer(s Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		key := strings.TrimPrefix(req.URL.Path, "/")
+		if checkKey(key) != nil {
+			http.NotFound(w, req)
+			return
+		}
+		info, err := s.Stat(key)
+		if err != nil {
+			serveError(w, req, err)
+			return
+		}
+
+		// http times have second precision
+		modified := info.Modified.Truncate(time.Second)
+		if since, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil &&
+			!modified.IsZero() && !modified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		if ctype := mime.TypeByExtension(path.Ext(key)); ctype != "" {
+			w.Header().Set("Content-Type", ctype)
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		if !modified.IsZero() {
+			w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		}
+		if req.Method == http.MethodHead {
+			return
+		}
+
+		blob, err := s.Get(key)
+		if err != nil {
+			serveError(w, req, err)
+			return
+		}
+		defer blob.Close()
+
+		// blobs which can seek (eg Local's files) also support range
+		// requests, which browsers use to load large images and video
+		if rs, ok := blob.(io.ReadSeeker); ok {
+			w.Header().Del("Content-Length") // ServeContent sets it per range
+			http.ServeContent(w, req, key, info.Modified, rs)
+			return
+		}
+		if _, err := io.Copy(w, blob); err != nil {
+			log.Printf(log.Debug, "error serving %s: %s", key, err)
+		}
+	})
+}
+
+// serveError responds 404 if err is caused by a key which doesn't exist,
+// otherwise 500.
+func serveError(w http.ResponseWriter, req *http.Request, err error) {
+	if IsNotExist(err) {
+		http.NotFound(w, req)
+		return
+	}
+	log.Printf(log.Error, "error serving %s: %s", req.URL.Path, err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
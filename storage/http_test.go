@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileServer(t *testing.T) {
+	root, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s := Local{Root: root}
+	if err := s.Put("mt_hood/palmer/1.jpg", strings.NewReader("image")); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.StripPrefix("/img/", FileServer(s)))
+	defer server.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		since  time.Time
+		status int
+		body   string
+	}{
+		{http.MethodGet, "/img/mt_hood/palmer/1.jpg", time.Time{}, http.StatusOK, "image"},
+		{http.MethodHead, "/img/mt_hood/palmer/1.jpg", time.Time{}, http.StatusOK, ""},
+		{http.MethodGet, "/img/mt_hood/palmer/1.jpg", time.Now().Add(time.Hour), http.StatusNotModified, ""},
+		{http.MethodGet, "/img/mt_hood/palmer/1.jpg", time.Now().Add(-time.Hour), http.StatusOK, "image"},
+		{http.MethodGet, "/img/mt_hood/palmer/2.jpg", time.Time{}, http.StatusNotFound, ""},
+		{http.MethodGet, "/img/mt_hood/palmer/", time.Time{}, http.StatusNotFound, ""},
+		{http.MethodGet, "/img/mt_hood/palmer", time.Time{}, http.StatusNotFound, ""},
+		{http.MethodPut, "/img/mt_hood/palmer/1.jpg", time.Time{}, http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, server.URL+tt.path, nil)
+		if !tt.since.IsZero() {
+			req.Header.Set("If-Modified-Since", tt.since.UTC().Format(http.TimeFormat))
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+		}
+		if tt.body != "" && string(body) != tt.body {
+			t.Errorf("%s %s: got body %q, want %q", tt.method, tt.path, body, tt.body)
+		}
+		if resp.StatusCode == http.StatusOK && resp.Header.Get("Content-Type") != "image/jpeg" {
+			t.Errorf("%s %s: content type %q", tt.method, tt.path, resp.Header.Get("Content-Type"))
+		}
+	}
+}
+
+// unseekable is a Storage whose blobs can't seek, like S3's.
+type unseekable struct{ Local }
+
+func (u unseekable) Get(key string) (io.ReadCloser, error) {
+	blob, err := u.Local.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return struct{ io.ReadCloser }{blob}, nil
+}
+
+func TestFileServerRange(t *testing.T) {
+	root, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s := Local{Root: root}
+	if err := s.Put("mt_hood/palmer/1.jpg", strings.NewReader("image")); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(root, "mt_hood", "palmer", "1.jpg"), modified, modified)
+
+	tests := []struct {
+		storage Storage
+		ifRange time.Time
+		status  int
+		body    string
+	}{
+		{s, time.Time{}, http.StatusPartialContent, "mag"},
+		{s, modified, http.StatusPartialContent, "mag"},
+		{s, modified.Add(-time.Minute), http.StatusOK, "image"}, // changed since
+		{unseekable{s}, time.Time{}, http.StatusOK, "image"},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/mt_hood/palmer/1.jpg", nil)
+		req.Header.Set("Range", "bytes=1-3")
+		if !tt.ifRange.IsZero() {
+			req.Header.Set("If-Range", tt.ifRange.UTC().Format(http.TimeFormat))
+		}
+		w := httptest.NewRecorder()
+		FileServer(tt.storage).ServeHTTP(w, req)
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Errorf("test %d: got %d %q, want %d %q", i, w.Code, w.Body, tt.status, tt.body)
+		}
+		if n := w.Header().Get("Content-Length"); n != strconv.Itoa(len(tt.body)) {
+			t.Errorf("test %d: content length %s, want %d", i, n, len(tt.body))
+		}
+	}
+}
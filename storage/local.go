@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Local is a Storage which stores blobs as files in the directory Root.
+// A blob's key is the slash separated path of its file relative to Root.
+type Local struct {
+	Root string
+}
+
+func (l Local) String() string { return l.Root }
+
+// path returns the filename of the blob stored as key.
+func (l Local) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.Root, filepath.FromSlash(key)), nil
+}
+
+// Put writes the blob to a temporary file which is renamed to the blob's
+// file once complete, so that the file is never partially written.
+func (l Local) Put(key string, r io.Reader) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(name)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "putting %s", key)
+	}
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(name)+".*"+tempSuffix)
+	if err != nil {
+		return errors.Wrapf(err, "putting %s", key)
+	}
+	_, err = io.Copy(f, r)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return errors.Wrapf(err, "putting %s", key)
+}
+
+// tempSuffix is the suffix of the temporary files written by Put.
+const tempSuffix = ".tmp"
+
+// isTemp reports if the file name is a temporary file written by Put.
+func isTemp(name string) bool {
+	base := filepath.Base(name)
+	return strings.HasPrefix(base, ".") && strings.HasSuffix(base, tempSuffix)
+}
+
+func (l Local) Get(key string) (io.ReadCloser, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, errors.Wrap(ErrNotExist, key)
+	}
+	return f, errors.Wrapf(err, "getting %s", key)
+}
+
+func (l Local) Stat(key string) (Info, error) {
+	name, err := l.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	fi, err := os.Stat(name)
+	if os.IsNotExist(err) || (err == nil && fi.IsDir()) {
+		return Info{}, errors.Wrap(ErrNotExist, key)
+	}
+	if err != nil {
+		return Info{}, errors.Wrapf(err, "stat %s", key)
+	}
+	return Info{Key: key, Size: fi.Size(), Modified: fi.ModTime()}, nil
+}
+
+func (l Local) List(prefix string) ([]Info, error) {
+	// only walk the directory containing the prefix
+	dir := filepath.Join(l.Root, filepath.FromSlash(path.Dir(prefix)))
+	if !strings.Contains(prefix, "/") {
+		dir = l.Root
+	}
+
+	var infos []Info
+	err := filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(l.Root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if fi.IsDir() {
+			// skip directories which can't contain the prefix
+			if key != "." && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(key, prefix) && !isTemp(name) {
+			infos = append(infos, Info{Key: key, Size: fi.Size(), Modified: fi.ModTime()})
+		}
+		return nil
+	})
+	return infos, errors.Wrapf(err, "listing %s", prefix)
+}
+
+func (l Local) Delete(key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return errors.Wrapf(err, "deleting %s", key)
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestLocal(t *testing.T) {
+	root, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	testStorage(t, Local{Root: root})
+}
+
+// failingReader returns some content and then an error, like an image
+// whose encoding fails.
+type failingReader struct{ read bool }
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.read {
+		return 0, errors.New("encoding failed")
+	}
+	r.read = true
+	return copy(p, "partial"), nil
+}
+
+func TestLocalPutAtomic(t *testing.T) {
+	root, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	s := Local{Root: root}
+
+	const key = "mt_hood/palmer/1.jpg"
+	if err := s.Put(key, strings.NewReader("complete")); err != nil {
+		t.Fatal(err)
+	}
+	// a failed put leaves the previous content and no temporary file
+	if err := s.Put(key, &failingReader{}); err == nil {
+		t.Error("put from failing reader succeeded")
+	}
+	r, err := s.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(data) != "complete" {
+		t.Errorf("after failed put got %q, want %q", data, "complete")
+	}
+	files, _ := filepath.Glob(filepath.Join(root, "mt_hood", "palmer", "*"))
+	temps, _ := filepath.Glob(filepath.Join(root, "mt_hood", "palmer", ".*"))
+	if len(files) != 1 || len(temps) != 0 {
+		t.Errorf("files after failed put: %q %q", files, temps)
+	}
+
+	// temporary files of puts in progress aren't listed
+	pr, pw := io.Pipe()
+	done := make(chan error)
+	go func() { done <- s.Put("mt_hood/palmer/2.jpg", pr) }()
+	pw.Write([]byte("in progress"))
+	infos, err := s.List("mt_hood/")
+	if err != nil || len(infos) != 1 || infos[0].Key != key {
+		t.Errorf("list during put got %+v (%v)", infos, err)
+	}
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if infos, _ := s.List("mt_hood/"); len(infos) != 2 {
+		t.Errorf("list after put got %+v", infos)
+	}
+}
@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// S3 is a Storage which stores blobs as the objects of a bucket of an
+// S3-compatible object store, such as AWS S3 or MinIO. A blob's key is its
+// object's key. Requests are signed with AWS signature version 4.
+type S3 struct {
+	Endpoint  *url.URL
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+	Client    *http.Client
+
+	// now returns the time requests are signed. nil is time.Now.
+	now func() time.Time
+}
+
+// defaultS3Timeout is the timeout of S3 requests if Config.TimeoutSec is 0.
+const defaultS3Timeout = 60 * time.Second
+
+// NewS3 makes an S3 from the S3 settings of cfg. The region defaults to
+// us-east-1. Its Client has cfg's timeout, so that a stalled endpoint
+// doesn't block its callers indefinitely.
+func NewS3(cfg Config) (*S3, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3 storage requires an endpoint and bucket")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, errors.Errorf("invalid s3 endpoint %q", cfg.Endpoint)
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	timeout := defaultS3Timeout
+	if cfg.TimeoutSec > 0 {
+		timeout = time.Duration(cfg.TimeoutSec) * time.Second
+	}
+	return &S3{
+		Endpoint:  endpoint,
+		Bucket:    cfg.Bucket,
+		Region:    region,
+		AccessKey: cfg.AccessKey,
+		SecretKey: cfg.SecretKey,
+		PathStyle: cfg.PathStyle,
+		Client:    &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (s *S3) String() string { return "s3://" + s.Bucket }
+
+func (s *S3) Put(key string, r io.Reader) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	// the payload is signed, so it's read entirely
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrapf(err, "putting %s", key)
+	}
+	resp, err := s.do(http.MethodPut, key, nil, body)
+	if err != nil {
+		return errors.Wrapf(err, "putting %s", key)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(key string) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	resp, err := s.do(http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting %s", key)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Stat(key string) (Info, error) {
+	if err := checkKey(key); err != nil {
+		return Info{}, err
+	}
+	resp, err := s.do(http.MethodHead, key, nil, nil)
+	if err != nil {
+		return Info{}, errors.Wrapf(err, "stat %s", key)
+	}
+	resp.Body.Close()
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Info{Key: key, Size: resp.ContentLength, Modified: modified}, nil
+}
+
+// listResult is the response of ListObjectsV2.
+type listResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *S3) List(prefix string) ([]Info, error) {
+	var infos []Info
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := s.do(http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing %s", prefix)
+		}
+		var result listResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing %s", prefix)
+		}
+
+		for _, obj := range result.Contents {
+			infos = append(infos, Info{Key: obj.Key, Size: obj.Size, Modified: obj.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return infos, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s *S3) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodDelete, key, nil, nil)
+	if IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "deleting %s", key)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3Error is the body of an error response.
+type s3Error struct {
+	Code    string
+	Message string
+}
+
+// do sends a signed request for the object key (or the bucket if key is
+// empty). A response which isn't 2xx is returned as an error, caused by
+// ErrNotExist if it's 404.
+func (s *S3) do(method, key string, query url.Values, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.url(key, query), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	s.sign(req, body, now())
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotExist
+	}
+	var e s3Error
+	xml.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&e)
+	if e.Code == "" {
+		return nil, errors.Errorf("s3: %s", resp.Status)
+	}
+	return nil, errors.Errorf("s3: %s: %s: %s", resp.Status, e.Code, e.Message)
+}
+
+// url returns the url of the object key in the bucket.
+func (s *S3) url(key string, query url.Values) string {
+	u := *s.Endpoint
+	p := "/" + key
+	if s.PathStyle {
+		p = "/" + s.Bucket + p
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(s.Endpoint.Path, "/") + p
+	u.RawPath = strings.TrimSuffix(s.Endpoint.EscapedPath(), "/") + uriEncode(p, false)
+	u.RawQuery = canonicalQuery(query)
+	return u.String()
+}
+
+const (
+	amzDate   = "X-Amz-Date"
+	amzSHA256 = "X-Amz-Content-Sha256"
+
+	signAlgorithm = "AWS4-HMAC-SHA256"
+	timeFormat    = "20060102T150405Z"
+)
+
+// sign signs req, whose body is body, at time t with AWS signature
+// version 4. It signs the host and every header of req except
+// Authorization and User-Agent.
+func (s *S3) sign(req *http.Request, body []byte, t time.Time) {
+	t = t.UTC()
+	payload := sha256.Sum256(body)
+	req.Header.Set(amzDate, t.Format(timeFormat))
+	req.Header.Set(amzSHA256, hex.EncodeToString(payload[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "authorization" || name == "user-agent" {
+			continue
+		}
+		headers[name] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payload[:]),
+	}, "\n")
+
+	date := t.Format("20060102")
+	scope := strings.Join([]string{date, s.Region, "s3", "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		signAlgorithm,
+		t.Format(timeFormat),
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range []string{date, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signAlgorithm, s.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes query sorted by key, as required by signature
+// version 4.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent encodes every byte of s except unreserved characters
+// and, unless encodeSlash, "/".
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteString("%" + strings.ToUpper(strconv.FormatUint(uint64(c)|0x100, 16)[1:]))
+		}
+	}
+	return b.String()
+}
+
+func hexSHA256(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,107 @@
+// Package storage stores blobs, such as scraped images, by key. Keys are
+// slash separated paths, such as "mt_hood/palmer/1565257200.jpg".
+//
+// A Storage is opened from a Config, which selects its driver: a directory
+// of the local filesystem (Local) or an S3-compatible object store (S3).
+package storage
+
+import (
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Storage stores blobs by key.
+type Storage interface {
+	// Put stores the content read from r as key, replacing any blob
+	// already stored as key.
+	Put(key string, r io.Reader) error
+	// Get opens the blob stored as key, which must be closed.
+	Get(key string) (io.ReadCloser, error)
+	// Stat gets information about the blob stored as key.
+	Stat(key string) (Info, error)
+	// List gets information about every blob whose key starts with
+	// prefix, ordered by key.
+	List(prefix string) ([]Info, error)
+	// Delete removes the blob stored as key. Deleting a key which doesn't
+	// exist isn't an error.
+	Delete(key string) error
+}
+
+// Info describes a stored blob.
+type Info struct {
+	Key      string
+	Size     int64
+	Modified time.Time
+}
+
+// ErrNotExist is the cause of errors for keys which don't exist.
+var ErrNotExist = errors.New("blob does not exist")
+
+// IsNotExist reports if err is caused by a key which doesn't exist.
+func IsNotExist(err error) bool {
+	return errors.Cause(err) == ErrNotExist
+}
+
+// Drivers of a Config.
+const (
+	DriverLocal = "local"
+	DriverS3    = "s3"
+)
+
+// Config selects and configures the driver of a Storage.
+type Config struct {
+	// Driver is DriverLocal (the default) or DriverS3.
+	Driver string
+
+	// root directory of the local driver
+	Root string
+
+	// S3 endpoint (eg https://s3.us-west-2.amazonaws.com or
+	// http://localhost:9000), bucket, region, and credentials. PathStyle
+	// puts the bucket in the url's path instead of its host, which most
+	// S3-compatible stores (eg MinIO) require.
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+	// TimeoutSec limits the time of each S3 request, including reading
+	// its response. 0 is 60 seconds
+	TimeoutSec int
+}
+
+// Open opens the Storage configured by cfg.
+func Open(cfg Config) (Storage, error) {
+	switch cfg.Driver {
+	case "", DriverLocal:
+		if cfg.Root == "" {
+			return nil, errors.New("local storage requires a root directory")
+		}
+		return Local{Root: cfg.Root}, nil
+
+	case DriverS3:
+		return NewS3(cfg)
+	}
+	return nil, errors.Errorf("unknown storage driver %q", cfg.Driver)
+}
+
+// Key joins elements (eg a mountain's pathname, camera's pathname, and
+// filename) into a key.
+func Key(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// checkKey returns an error if key isn't a clean relative slash separated
+// path, so that it can't refer outside of a Local root.
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key ||
+		key == ".." || strings.HasPrefix(key, "../") || strings.Contains(key, "\\") {
+		return errors.Errorf("invalid key %q", key)
+	}
+	return nil
+}
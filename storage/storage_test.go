@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testStorage tests the behavior shared by every Storage driver.
+func testStorage(t *testing.T, s Storage) {
+	t.Helper()
+	blobs := map[string]string{
+		"mt_hood/palmer/1.jpg":   "first",
+		"mt_hood/palmer/2.jpg":   "second",
+		"mt_hood/timberline.jpg": "third",
+		"mt hood/cam+1/3.jpg":    "fourth",
+	}
+	for key, content := range blobs {
+		if err := s.Put(key, strings.NewReader(content)); err != nil {
+			t.Fatalf("put %s: %s", key, err)
+		}
+	}
+	// replaces the content
+	if err := s.Put("mt_hood/palmer/2.jpg", strings.NewReader("2nd")); err != nil {
+		t.Fatal(err)
+	}
+	blobs["mt_hood/palmer/2.jpg"] = "2nd"
+
+	for key, content := range blobs {
+		r, err := s.Get(key)
+		if err != nil {
+			t.Errorf("get %s: %s", key, err)
+			continue
+		}
+		data, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(data, []byte(content)) {
+			t.Errorf("get %s: got %q (%v), want %q", key, data, err, content)
+		}
+
+		info, err := s.Stat(key)
+		if err != nil || info.Key != key || info.Size != int64(len(content)) || info.Modified.IsZero() {
+			t.Errorf("stat %s: got %+v (%v)", key, info, err)
+		}
+	}
+
+	if _, err := s.Get("mt_hood/palmer/3.jpg"); !IsNotExist(err) {
+		t.Errorf("get missing key: got %v, want not exist", err)
+	}
+	if _, err := s.Stat("mt_hood/palmer"); !IsNotExist(err) {
+		t.Errorf("stat directory: got %v, want not exist", err)
+	}
+	for _, key := range []string{"", "/abs.jpg", "../up.jpg", "a/../../b.jpg", "a//b.jpg"} {
+		if err := s.Put(key, strings.NewReader("x")); err == nil {
+			t.Errorf("put invalid key %q", key)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		keys   []string
+	}{
+		{"mt_hood/palmer/", []string{"mt_hood/palmer/1.jpg", "mt_hood/palmer/2.jpg"}},
+		{"mt_hood/", []string{"mt_hood/palmer/1.jpg", "mt_hood/palmer/2.jpg", "mt_hood/timberline.jpg"}},
+		{"mt_hood/t", []string{"mt_hood/timberline.jpg"}},
+		{"mt", []string{"mt hood/cam+1/3.jpg", "mt_hood/palmer/1.jpg", "mt_hood/palmer/2.jpg", "mt_hood/timberline.jpg"}},
+		{"", []string{"mt hood/cam+1/3.jpg", "mt_hood/palmer/1.jpg", "mt_hood/palmer/2.jpg", "mt_hood/timberline.jpg"}},
+		{"mt_shasta/", nil},
+	}
+	for _, tt := range tests {
+		infos, err := s.List(tt.prefix)
+		if err != nil {
+			t.Errorf("list %q: %s", tt.prefix, err)
+			continue
+		}
+		var keys []string
+		for _, info := range infos {
+			keys = append(keys, info.Key)
+			if info.Size != int64(len(blobs[info.Key])) {
+				t.Errorf("list %q: %s size %d", tt.prefix, info.Key, info.Size)
+			}
+		}
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("list %q: got %q, want %q", tt.prefix, keys, tt.keys)
+		}
+	}
+
+	if err := s.Delete("mt_hood/palmer/1.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat("mt_hood/palmer/1.jpg"); !IsNotExist(err) {
+		t.Errorf("stat deleted key: got %v, want not exist", err)
+	}
+	if err := s.Delete("mt_hood/palmer/1.jpg"); err != nil {
+		t.Errorf("delete missing key: %s", err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	tests := []struct {
+		cfg Config
+		ok  bool
+	}{
+		{Config{Root: "images"}, true},
+		{Config{Driver: DriverLocal}, false},
+		{Config{Driver: DriverS3, Endpoint: "http://localhost:9000", Bucket: "mtcam"}, true},
+		{Config{Driver: DriverS3, Endpoint: "localhost:9000", Bucket: "mtcam"}, false},
+		{Config{Driver: DriverS3, Endpoint: "http://localhost:9000"}, false},
+		{Config{Driver: "ftp"}, false},
+	}
+	for _, tt := range tests {
+		if _, err := Open(tt.cfg); (err == nil) != tt.ok {
+			t.Errorf("open %+v: got error %v, want ok %t", tt.cfg, err, tt.ok)
+		}
+	}
+}
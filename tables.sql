@@ -33,6 +33,9 @@ CREATE TABLE IF NOT EXISTS "camera" (
     "longitude" REAL NOT NULL, 
     -- go text template evaluating to an URL for the camera image
     "url" TEXT NOT NULL,
+    -- expression extracting the image url from the html page at url, eg
+    -- 'css:img#cam'. see pipeline.ParseExtractor. '' if url is the image
+    "extract" TEXT NOT NULL DEFAULT '',
     -- file extention (eg 'jpg') of image, NO period
     "file_ext" TEXT NOT NULL, 
     -- main camera on/off switch
@@ -51,6 +54,33 @@ CREATE TABLE IF NOT EXISTS "camera" (
     "pathname" TEXT NOT NULL DEFAULT '',
     -- FK to mountain
     "mountain_id" INTEGER NOT NULL, 
+    -- JSON array of the stages scraping the camera. '' is the default
+    -- stages. eg [{"stage": "fetch"}, {"stage": "decode"}, {"stage": "write"}]
+    "pipeline" TEXT NOT NULL DEFAULT '',
+    -- JSON object customizing the request for the camera image (headers,
+    -- auth, etc). '' is a plain GET. eg {"headers": {"Referer": "..."}}
+    "request" TEXT NOT NULL DEFAULT '',
+    -- JSON object overriding scraped's image settings (size, quality,
+    -- format, crop, etc) for the camera. '' uses scraped's settings.
+    -- eg {"width": 1920, "crop": {"x": 0, "y": 40, "width": 0, "height": 0}}
+    "image" TEXT NOT NULL DEFAULT '',
+    -- JSON object replacing scraped's retention policy for the camera's
+    -- images. '' uses scraped's policy.
+    -- eg {"tiers": [{"after_days": 30, "every_min": 60}], "max_images": 100000}
+    "retention" TEXT NOT NULL DEFAULT '',
+    -- health of the camera, set by scraped. 'healthy', 'degraded',
+    -- 'frozen', or 'down'
+    "health" TEXT NOT NULL DEFAULT 'healthy',
+    -- time health last changed. NULL if never
+    "health_changed" DATETIME,
+    -- number of consecutive scrapes of duplicate images, and of
+    -- consecutive failed scrapes (not counting duplicates)
+    "duplicates" INTEGER NOT NULL DEFAULT 0,
+    "failures" INTEGER NOT NULL DEFAULT 0,
+    -- ETag and Last-Modified headers of the camera's latest fetched image,
+    -- sent in the next request to fetch it only if it changed. '' if none
+    "etag" TEXT NOT NULL DEFAULT '',
+    "last_modified" TEXT NOT NULL DEFAULT '',
     FOREIGN KEY ("mountain_id") REFERENCES "mountain" ("rowid"));
 
 CREATE INDEX "camera_mountain_id" ON "camera" ("mountain_id");
@@ -60,7 +90,7 @@ CREATE TABLE IF NOT EXISTS "scrape" (
 
     -- time this scrape was performed
     "created" DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP, 
-    -- result such as 'success', 'failure', etc.
+    -- result such as 'success', 'failure', 'unchanged', 'rejected', etc.
     "result" TEXT NOT NULL, 
     -- details relating to the result
     "detail" TEXT NOT NULL DEFAULT '', 
@@ -69,6 +99,32 @@ CREATE TABLE IF NOT EXISTS "scrape" (
     "filename" TEXT NOT NULL, 
     -- FK to camera
     "camera_id" INTEGER NOT NULL, 
+    -- perceptual hash (dHash) of the image as 16 hex digits. '' if none
+    "hash" TEXT NOT NULL DEFAULT '',
+    -- size in bytes and SHA-256 checksum (64 hex digits) of the image
+    -- file. 0 and '' if none
+    "size" INTEGER NOT NULL DEFAULT 0,
+    "sha256" TEXT NOT NULL DEFAULT '',
+    -- time the image file was deleted by scraped's retention policy.
+    -- NULL if it wasn't
+    "pruned" DATETIME,
+    -- comma separated names of the thumbnails written next to the image
+    -- file (eg 'small,medium'). '' if none
+    "thumbnails" TEXT NOT NULL DEFAULT '',
     FOREIGN KEY ("camera_id") REFERENCES "camera" ("rowid"));
     
 CREATE INDEX "scrape_camera_id" ON "scrape" ("camera_id");
+
+CREATE TABLE IF NOT EXISTS "task" (
+    -- rowid auto PK
+
+    -- kind of task, used by scraped to recreate the task
+    "kind" TEXT NOT NULL,
+    -- JSON encoded arguments of the task
+    "args" TEXT NOT NULL DEFAULT '',
+    -- time the task is to be run
+    "due" DATETIME NOT NULL,
+    -- attempt number of a task being retried. 0 if not a retry
+    "attempt" INTEGER NOT NULL DEFAULT 0,
+    -- time the first attempt of a retried task was due. NULL if not a retry
+    "first_due" DATETIME);
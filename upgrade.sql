@@ -0,0 +1,51 @@
+/*
+upgrade an existing v2.0 database in place.
+each section is applied once, in order, to bring an older database
+up to date with tables.sql.
+*/
+
+/* persistent scheduler queue */
+CREATE TABLE IF NOT EXISTS "task" (
+    "kind" TEXT NOT NULL,
+    "args" TEXT NOT NULL DEFAULT '',
+    "due" DATETIME NOT NULL);
+
+/* retries of scheduler tasks */
+ALTER TABLE "task" ADD COLUMN "attempt" INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "task" ADD COLUMN "first_due" DATETIME;
+
+/* per camera scrape pipeline */
+ALTER TABLE "camera" ADD COLUMN "pipeline" TEXT NOT NULL DEFAULT '';
+
+/* perceptual hash of scraped images. run hashscrapes to hash existing images */
+ALTER TABLE "scrape" ADD COLUMN "hash" TEXT NOT NULL DEFAULT '';
+
+/* camera health */
+ALTER TABLE "camera" ADD COLUMN "health" TEXT NOT NULL DEFAULT 'healthy';
+ALTER TABLE "camera" ADD COLUMN "health_changed" DATETIME;
+ALTER TABLE "camera" ADD COLUMN "duplicates" INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "camera" ADD COLUMN "failures" INTEGER NOT NULL DEFAULT 0;
+
+/* conditional fetching of camera images */
+ALTER TABLE "camera" ADD COLUMN "etag" TEXT NOT NULL DEFAULT '';
+ALTER TABLE "camera" ADD COLUMN "last_modified" TEXT NOT NULL DEFAULT '';
+
+/* per camera request customization */
+ALTER TABLE "camera" ADD COLUMN "request" TEXT NOT NULL DEFAULT '';
+
+/* image urls extracted from camera pages */
+ALTER TABLE "camera" ADD COLUMN "extract" TEXT NOT NULL DEFAULT '';
+
+/* per camera image settings */
+ALTER TABLE "camera" ADD COLUMN "image" TEXT NOT NULL DEFAULT '';
+
+/* size and checksum of scraped images */
+ALTER TABLE "scrape" ADD COLUMN "size" INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "scrape" ADD COLUMN "sha256" TEXT NOT NULL DEFAULT '';
+
+/* retention of scraped images */
+ALTER TABLE "camera" ADD COLUMN "retention" TEXT NOT NULL DEFAULT '';
+ALTER TABLE "scrape" ADD COLUMN "pruned" DATETIME;
+
+/* thumbnails of scraped images. run thumbscrapes to make thumbnails of existing images */
+ALTER TABLE "scrape" ADD COLUMN "thumbnails" TEXT NOT NULL DEFAULT '';